@@ -0,0 +1,106 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// BytecodeFieldTag is the tag of a bytecode table row, following the circuit's
+// BytecodeFieldTag.
+type BytecodeFieldTag uint64
+
+const (
+	BytecodeLength BytecodeFieldTag = iota
+	BytecodeByte
+	BytecodePadding
+)
+
+// Bytecode is a code blob executed by the EVM, keyed by its hash.
+type Bytecode struct {
+	Hash common.Hash
+	Code []byte
+}
+
+// BytecodeRow is a row of the bytecode table. The Length row of a bytecode
+// carries the code length in Value, each Byte row the byte at Index and whether
+// it's an opcode (IsCode) or push data.
+type BytecodeRow struct {
+	Hash   common.Hash
+	Tag    BytecodeFieldTag
+	Index  uint64
+	IsCode bool
+	Value  uint64
+}
+
+// Rows returns the bytecode table rows of the code: a Length row followed by a
+// Byte row per byte.
+func (b *Bytecode) Rows() []BytecodeRow {
+	rows := make([]BytecodeRow, 0, len(b.Code)+1)
+	rows = append(rows, BytecodeRow{Hash: b.Hash, Tag: BytecodeLength, Value: uint64(len(b.Code))})
+
+	var pushData int
+	for i, c := range b.Code {
+		isCode := pushData == 0
+		if isCode && OpCode(c).IsPush() {
+			pushData = int(c) - int(PUSH1) + 1
+		} else if !isCode {
+			pushData--
+		}
+		rows = append(rows, BytecodeRow{Hash: b.Hash, Tag: BytecodeByte, Index: uint64(i), IsCode: isCode, Value: uint64(c)})
+	}
+	return rows
+}
+
+// BytecodeTable returns the rows of the bytecode table for the given codes,
+// padded with Padding rows up to size. No padding is added if the codes need
+// more rows than size.
+func BytecodeTable(codes []*Bytecode, size int) []BytecodeRow {
+	var rows []BytecodeRow
+	for _, code := range codes {
+		rows = append(rows, code.Rows()...)
+	}
+	for len(rows) < size {
+		rows = append(rows, BytecodeRow{Tag: BytecodePadding})
+	}
+	return rows
+}
+
+// recordBytecode remembers a code blob run by the EVM, once per code hash.
+func (evm *EVM) recordBytecode(hash common.Hash, code []byte) {
+	if !evm.Config.EnableBytecodeRecording {
+		return
+	}
+	if _, ok := evm.bytecodeSeen[hash]; ok {
+		return
+	}
+	if evm.bytecodeSeen == nil {
+		evm.bytecodeSeen = make(map[common.Hash]struct{})
+	}
+	evm.bytecodeSeen[hash] = struct{}{}
+	evm.bytecodes = append(evm.bytecodes, &Bytecode{Hash: hash, Code: common.CopyBytes(code)})
+}
+
+// Bytecodes returns every distinct code executed by the EVM since it was
+// created, in order of first execution, including the init code of contract
+// creations. Codes are only recorded if Config.EnableBytecodeRecording is set.
+//
+// The EVM is reset between the transactions of a block, so after the block has
+// been processed the result covers the whole block.
+func (evm *EVM) Bytecodes() []*Bytecode {
+	return evm.bytecodes
+}
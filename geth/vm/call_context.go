@@ -0,0 +1,217 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// CallContextFieldTag selects the call context field read or written by an
+// RwCallContext operation. The values follow the circuit's CallContextFieldTag.
+type CallContextFieldTag uint64
+
+const (
+	CallContextRwCounterEndOfReversion CallContextFieldTag = iota + 1
+	CallContextCallerID
+	CallContextTxID
+	CallContextDepth
+	CallContextCallerAddress
+	CallContextCalleeAddress
+	CallContextCallDataOffset
+	CallContextCallDataLength
+	CallContextReturnDataOffset
+	CallContextReturnDataLength
+	CallContextValue
+	CallContextIsSuccess
+	CallContextIsPersistent
+	CallContextIsStatic
+
+	CallContextLastCalleeID
+	CallContextLastCalleeReturnDataOffset
+	CallContextLastCalleeReturnDataLength
+
+	CallContextIsRoot
+	CallContextIsCreate
+	CallContextCodeSource
+	CallContextProgramCounter
+	CallContextStackPointer
+	CallContextGasLeft
+	CallContextMemorySize
+	CallContextStateWriteCounter
+)
+
+// CallContext is the record of a call frame opened by Call, CallCode,
+// DelegateCall, StaticCall or create, carrying the fields the circuit keeps in
+// the call context of the frame.
+//
+// IsSuccess is set when the frame exits. IsPersistent depends on the outcome of
+// every ancestor, so it's only final once the root frame has exited.
+// RwCounterEndOfReversion is left for rw aware loggers to fill in.
+type CallContext struct {
+	ID       uint64 // Index of the frame within the transaction, starting at 1
+	CallerID uint64 // ID of the calling frame, 0 for the root frame
+	TxID     uint64 // Index of the transaction within the block, as set in the TxContext
+	Depth    int    // Depth of the frame in the call stack, 1 for the root frame
+	Type     OpCode
+
+	CallerAddress common.Address
+	CalleeAddress common.Address
+
+	CallDataOffset   uint64
+	CallDataLength   uint64
+	ReturnDataOffset uint64
+	ReturnDataLength uint64
+
+	Value *big.Int
+
+	IsSuccess    bool
+	IsPersistent bool
+	IsStatic     bool
+	IsRoot       bool
+	IsCreate     bool
+
+	CodeSource              common.Hash // Hash of the code executed by the frame
+	RwCounterEndOfReversion uint64
+	ProvingCost             uint64 // Proving cost of the frame's own execution, excluding its callees
+
+	parent *CallContext
+}
+
+// CallContextLogger is an optional extension of EVMLogger, notified about the
+// call context of every frame. CaptureCallContextEnter is invoked right after
+// CaptureStart/CaptureEnter and CaptureCallContextExit right before
+// CaptureEnd/CaptureExit.
+type CallContextLogger interface {
+	CaptureCallContextEnter(ctx *CallContext)
+	CaptureCallContextExit(ctx *CallContext)
+}
+
+// callArgs is the memory layout of the call data and return data of a CALL
+// family opcode. For the CREATE family, the input is the init code.
+type callArgs struct {
+	inOffset, inSize   uint64
+	retOffset, retSize uint64
+}
+
+// callArgsFromStack reads the call data and return data layout of a CALL family
+// opcode, whose argument offset is the n'th stack item.
+func callArgsFromStack(stack *Stack, n int) callArgs {
+	return callArgs{
+		inOffset:  stack.Back(n).Uint64(),
+		inSize:    stack.Back(n + 1).Uint64(),
+		retOffset: stack.Back(n + 2).Uint64(),
+		retSize:   stack.Back(n + 3).Uint64(),
+	}
+}
+
+// CallContexts returns the call contexts of the frames opened by the current
+// transaction, in the order they were entered. Call contexts are only tracked
+// when tracing, copy event recording or proving cost accounting is enabled.
+func (evm *EVM) CallContexts() []*CallContext {
+	return evm.callContexts
+}
+
+// tracksCallContexts reports whether the EVM keeps the call context of frames.
+func (evm *EVM) tracksCallContexts() bool {
+	return evm.Config.Debug || evm.Config.EnableCopyEventRecording || evm.Config.ProvingCostSchedule != nil
+}
+
+// enterCallContext opens the call context of a new frame. For CALLCODE and
+// DELEGATECALL, callee is the address of the code run, which only determines
+// the code source.
+func (evm *EVM) enterCallContext(typ OpCode, caller, callee common.Address, input []byte, value *big.Int, codeHash common.Hash) *CallContext {
+	args := evm.callArgsTemp
+	evm.callArgsTemp = callArgs{}
+
+	ctx := &CallContext{
+		Depth:         evm.depth + 1,
+		Type:          typ,
+		CallerAddress: caller,
+		CalleeAddress: callee,
+		Value:         value,
+		IsStatic:      typ == STATICCALL,
+		IsRoot:        evm.depth == 0,
+		IsCreate:      typ == CREATE || typ == CREATE2,
+		CodeSource:    codeHash,
+	}
+	if ctx.IsRoot {
+		// A new root frame means a new transaction.
+		evm.callContexts, evm.callStack = nil, nil
+		ctx.CallDataLength = uint64(len(input))
+	} else {
+		ctx.parent = evm.callStack[len(evm.callStack)-1]
+		ctx.CallerID = ctx.parent.ID
+		ctx.IsStatic = ctx.IsStatic || ctx.parent.IsStatic
+		switch typ {
+		case CALLCODE:
+			// The callee code runs in the context of the caller.
+			ctx.CalleeAddress = caller
+		case DELEGATECALL:
+			// The callee code runs in the context of the caller, on behalf
+			// of its own caller and with its value.
+			ctx.CalleeAddress = caller
+			ctx.CallerAddress, ctx.Value = ctx.parent.CallerAddress, ctx.parent.Value
+		}
+		if !ctx.IsCreate {
+			ctx.CallDataOffset, ctx.CallDataLength = args.inOffset, args.inSize
+			ctx.ReturnDataOffset, ctx.ReturnDataLength = args.retOffset, args.retSize
+		}
+	}
+	if ctx.Value == nil {
+		ctx.Value = new(big.Int)
+	}
+	ctx.TxID = evm.TxContext.TxID
+	ctx.ID = uint64(len(evm.callContexts) + 1)
+
+	evm.callContexts = append(evm.callContexts, ctx)
+	evm.callStack = append(evm.callStack, ctx)
+
+	if logger, ok := evm.Config.Tracer.(CallContextLogger); ok {
+		logger.CaptureCallContextEnter(ctx)
+	}
+	if logger, ok := evm.Config.Tracer.(ExecutionStateLogger); ok && ctx.IsRoot {
+		logger.CaptureExecutionState(0, 0, ExecBeginTx, ctx.Depth)
+	}
+	return ctx
+}
+
+// exitCallContext closes the call context of the frame with the outcome of its
+// execution and the data it returned. Once the root frame exits, the
+// persistence of every frame of the transaction is known.
+func (evm *EVM) exitCallContext(ctx *CallContext, ret []byte, err error) {
+	ctx.IsSuccess = err == nil
+	evm.callStack = evm.callStack[:len(evm.callStack)-1]
+
+	if evm.Config.EnableCopyEventRecording && (err == nil || err == ErrExecutionReverted) {
+		evm.recordReturnDataCopy(ctx, ret)
+	}
+
+	if ctx.IsRoot {
+		// Parents always precede their children.
+		for _, c := range evm.callContexts {
+			c.IsPersistent = c.IsSuccess && (c.parent == nil || c.parent.IsPersistent)
+		}
+	}
+	if logger, ok := evm.Config.Tracer.(CallContextLogger); ok {
+		logger.CaptureCallContextExit(ctx)
+	}
+	if logger, ok := evm.Config.Tracer.(ExecutionStateLogger); ok && ctx.IsRoot {
+		logger.CaptureExecutionState(0, 0, ExecEndTx, ctx.Depth)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// CallFrame is a frame of the call tree built by the CallTracer, in the JSON
+// layout of geth's callTracer.
+type CallFrame struct {
+	Type         string          `json:"type"`
+	From         common.Address  `json:"from"`
+	To           *common.Address `json:"to,omitempty"`
+	Value        *hexutil.Big    `json:"value,omitempty"`
+	Gas          hexutil.Uint64  `json:"gas"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	Input        hexutil.Bytes   `json:"input"`
+	Output       hexutil.Bytes   `json:"output,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	RevertReason string          `json:"revertReason,omitempty"`
+	Calls        []*CallFrame    `json:"calls,omitempty"`
+}
+
+// FlatCallAction is the action of a FlatCallFrame. Calls fill From, To, Gas,
+// Input, Value and CallType, creations From, Gas, Init, Value and
+// CreationMethod, self-destructs Address, RefundAddress and Balance.
+type FlatCallAction struct {
+	CallType       string          `json:"callType,omitempty"`
+	CreationMethod string          `json:"creationMethod,omitempty"`
+	From           *common.Address `json:"from,omitempty"`
+	To             *common.Address `json:"to,omitempty"`
+	Gas            *hexutil.Uint64 `json:"gas,omitempty"`
+	Input          *hexutil.Bytes  `json:"input,omitempty"`
+	Init           *hexutil.Bytes  `json:"init,omitempty"`
+	Value          *hexutil.Big    `json:"value,omitempty"`
+	Address        *common.Address `json:"address,omitempty"`
+	RefundAddress  *common.Address `json:"refundAddress,omitempty"`
+	Balance        *hexutil.Big    `json:"balance,omitempty"`
+}
+
+// FlatCallResult is the outcome of a successful FlatCallFrame. Calls fill
+// Output, creations Address and Code.
+type FlatCallResult struct {
+	GasUsed hexutil.Uint64  `json:"gasUsed"`
+	Output  *hexutil.Bytes  `json:"output,omitempty"`
+	Address *common.Address `json:"address,omitempty"`
+	Code    *hexutil.Bytes  `json:"code,omitempty"`
+}
+
+// FlatCallFrame is a frame of the call tree in the flat layout of parity's
+// trace module, locating the frame in the tree by its TraceAddress.
+type FlatCallFrame struct {
+	Type         string          `json:"type"`
+	Action       FlatCallAction  `json:"action"`
+	Result       *FlatCallResult `json:"result"`
+	Error        string          `json:"error,omitempty"`
+	RevertReason string          `json:"revertReason,omitempty"`
+	Subtraces    int             `json:"subtraces"`
+	TraceAddress []int           `json:"traceAddress"`
+}
+
+// CallTracer is an EVMLogger building the tree of the frames opened by a
+// transaction, with their gas, outcome and decoded revert reason.
+//
+// The CallTracer is meant to trace a single transaction.
+type CallTracer struct {
+	callstack []*CallFrame
+	gasLimit  uint64
+}
+
+// NewCallTracer returns a new call tracer.
+func NewCallTracer() *CallTracer {
+	return new(CallTracer)
+}
+
+// CaptureTxStart implements the EVMLogger interface.
+func (t *CallTracer) CaptureTxStart(gasLimit uint64) {
+	t.gasLimit = gasLimit
+}
+
+// CaptureTxEnd implements the EVMLogger interface, accounting the intrinsic gas
+// and refund of the transaction to the root frame.
+func (t *CallTracer) CaptureTxEnd(restGas uint64) {
+	if len(t.callstack) > 0 {
+		t.callstack[0].Gas = hexutil.Uint64(t.gasLimit)
+		t.callstack[0].GasUsed = hexutil.Uint64(t.gasLimit - restGas)
+	}
+}
+
+// CaptureStart implements the EVMLogger interface.
+func (t *CallTracer) CaptureStart(env *EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	typ := CALL
+	if create {
+		typ = CREATE
+	}
+	t.callstack = []*CallFrame{newCallFrame(typ, from, to, input, gas, value)}
+}
+
+// CaptureEnd implements the EVMLogger interface.
+func (t *CallTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	t.callstack[0].finish(output, gasUsed, err)
+}
+
+// CaptureEnter implements the EVMLogger interface.
+func (t *CallTracer) CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.callstack = append(t.callstack, newCallFrame(typ, from, to, input, gas, value))
+}
+
+// CaptureExit implements the EVMLogger interface.
+func (t *CallTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	size := len(t.callstack)
+	if size <= 1 {
+		return
+	}
+	call := t.callstack[size-1]
+	t.callstack = t.callstack[:size-1]
+	call.finish(output, gasUsed, err)
+
+	parent := t.callstack[size-2]
+	parent.Calls = append(parent.Calls, call)
+}
+
+// CaptureState implements the EVMLogger interface.
+func (t *CallTracer) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, rData []byte, depth int, err error) {
+}
+
+// CaptureFault implements the EVMLogger interface.
+func (t *CallTracer) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, depth int, err error) {
+}
+
+func newCallFrame(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) *CallFrame {
+	frame := &CallFrame{
+		Type:  typ.String(),
+		From:  from,
+		To:    &to,
+		Gas:   hexutil.Uint64(gas),
+		Input: common.CopyBytes(input),
+	}
+	if value != nil {
+		frame.Value = (*hexutil.Big)(new(big.Int).Set(value))
+	}
+	return frame
+}
+
+// finish sets the outcome of the frame. The output of failed frames is only
+// kept if they reverted, as the revert data.
+func (f *CallFrame) finish(output []byte, gasUsed uint64, err error) {
+	f.GasUsed = hexutil.Uint64(gasUsed)
+	if err == nil {
+		f.Output = common.CopyBytes(output)
+		return
+	}
+	f.Error = err.Error()
+	if errors.Is(err, ErrExecutionReverted) && len(output) > 0 {
+		f.Output = common.CopyBytes(output)
+		f.RevertReason = decodeRevertReason(output)
+	}
+}
+
+// Result returns the root frame of the call tree, nil if nothing was traced.
+func (t *CallTracer) Result() *CallFrame {
+	if len(t.callstack) == 0 {
+		return nil
+	}
+	return t.callstack[0]
+}
+
+// GetResult returns the JSON encoded call tree.
+func (t *CallTracer) GetResult() (json.RawMessage, error) {
+	return json.Marshal(t.Result())
+}
+
+// FlatResult returns the frames of the call tree in the flat layout of parity's
+// trace module, in depth first order.
+func (t *CallTracer) FlatResult() []*FlatCallFrame {
+	root := t.Result()
+	if root == nil {
+		return []*FlatCallFrame{}
+	}
+	return flattenCallFrame(root, []int{}, nil)
+}
+
+// GetFlatResult returns the JSON encoded flat frames.
+func (t *CallTracer) GetFlatResult() (json.RawMessage, error) {
+	return json.Marshal(t.FlatResult())
+}
+
+func flattenCallFrame(frame *CallFrame, traceAddress []int, frames []*FlatCallFrame) []*FlatCallFrame {
+	flat := &FlatCallFrame{
+		Error:        frame.Error,
+		RevertReason: frame.RevertReason,
+		Subtraces:    len(frame.Calls),
+		TraceAddress: traceAddress,
+	}
+	switch frame.Type {
+	case CREATE.String(), CREATE2.String():
+		init := frame.Input
+		flat.Type = "create"
+		flat.Action = FlatCallAction{CreationMethod: strings.ToLower(frame.Type), From: &frame.From, Gas: &frame.Gas, Init: &init, Value: frame.Value}
+		if frame.Error == "" {
+			code := frame.Output
+			flat.Result = &FlatCallResult{GasUsed: frame.GasUsed, Address: frame.To, Code: &code}
+		}
+	case SELFDESTRUCT.String():
+		flat.Type = "suicide"
+		flat.Action = FlatCallAction{Address: &frame.From, RefundAddress: frame.To, Balance: frame.Value}
+	default:
+		input := frame.Input
+		flat.Type = "call"
+		flat.Action = FlatCallAction{CallType: strings.ToLower(frame.Type), From: &frame.From, To: frame.To, Gas: &frame.Gas, Input: &input, Value: frame.Value}
+		if flat.Action.Value == nil {
+			// Parity reports a zero value for calls that can't carry one.
+			flat.Action.Value = new(hexutil.Big)
+		}
+		if frame.Error == "" {
+			output := frame.Output
+			if output == nil {
+				output = hexutil.Bytes{}
+			}
+			flat.Result = &FlatCallResult{GasUsed: frame.GasUsed, Output: &output}
+		}
+	}
+	frames = append(frames, flat)
+	for i, call := range frame.Calls {
+		address := make([]int, len(traceAddress)+1)
+		copy(address, traceAddress)
+		address[len(traceAddress)] = i
+		frames = flattenCallFrame(call, address, frames)
+	}
+	return frames
+}
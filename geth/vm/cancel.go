@@ -0,0 +1,109 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// abortCheckInterval is the number of steps the interpreter executes between
+// two checks of the abort flag set by Cancel.
+const abortCheckInterval = 1024
+
+// aborted polls the abort flag once every abortCheckInterval steps, counted
+// across all frames so that deep call chains of short frames are bounded too.
+func (in *EVMInterpreter) aborted() bool {
+	in.steps++
+	if in.steps%abortCheckInterval != 0 {
+		return false
+	}
+	return atomic.LoadInt32(&in.evm.abort) != 0
+}
+
+// Aborted reports whether the interpreter stopped an execution on the abort
+// flag since CancelOnDone was last called. Unlike Cancelled, it isn't set by a
+// cancellation arriving once the execution is over.
+func (evm *EVM) Aborted() bool {
+	return evm.abortSeen
+}
+
+// CancelOnDone clears any previous cancellation of the EVM and cancels it once
+// ctx is done. The returned stop function must be called when the execution is
+// over, it waits for the watcher to exit.
+func (evm *EVM) CancelOnDone(ctx context.Context) (stop func()) {
+	atomic.StoreInt32(&evm.abort, 0)
+	evm.abortSeen = false
+
+	// A context done already cancels right away, the execution would race the
+	// watcher otherwise.
+	if ctx.Err() != nil {
+		evm.Cancel()
+		return func() {}
+	}
+	var (
+		done   = make(chan struct{})
+		exited = make(chan struct{})
+	)
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			evm.Cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		select {
+		case <-done:
+		default:
+			close(done)
+		}
+		<-exited
+	}
+}
+
+// CancelledError returns the error reporting an execution cancelled by ctx
+// after using gasUsed.
+func CancelledError(ctx context.Context, gasUsed uint64) *ErrExecutionCancelled {
+	cause := ctx.Err()
+	if cause == nil {
+		// Cancelled directly through Cancel.
+		cause = ErrExecutionAborted
+	}
+	return &ErrExecutionCancelled{Cause: cause, GasUsed: gasUsed}
+}
+
+// CallWithContext is Call, stopped once ctx is done. The interpreter notices
+// the cancellation within abortCheckInterval steps, or when entering the next
+// frame, in which case the error is an *ErrExecutionCancelled holding the gas
+// used until then.
+func (evm *EVM) CallWithContext(ctx context.Context, caller ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	stop := evm.CancelOnDone(ctx)
+	ret, leftOverGas, err = evm.Call(caller, addr, input, gas, value)
+	stop()
+
+	// An aborted callee may have been swallowed by its caller, the error
+	// alone doesn't tell.
+	if err == ErrExecutionAborted || evm.Aborted() {
+		err = CancelledError(ctx, gas-leftOverGas)
+	}
+	return ret, leftOverGas, err
+}
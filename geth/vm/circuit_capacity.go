@@ -0,0 +1,310 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Circuit identifies a circuit of the zkEVM whose row count is fixed.
+type Circuit int
+
+const (
+	CircuitEVM      Circuit = iota // rows counted in execution steps
+	CircuitState                   // rows counted in rw operations
+	CircuitKeccak                  // rows counted in keccak-f permutations
+	CircuitCopy                    // rows counted in copied bytes
+	CircuitBytecode                // rows counted in code bytes plus a length row per code
+
+	numCircuits
+)
+
+var circuitNames = [numCircuits]string{
+	CircuitEVM:      "evm",
+	CircuitState:    "state",
+	CircuitKeccak:   "keccak",
+	CircuitCopy:     "copy",
+	CircuitBytecode: "bytecode",
+}
+
+func (c Circuit) String() string {
+	if c < 0 || c >= numCircuits {
+		return fmt.Sprintf("circuit %d", int(c))
+	}
+	return circuitNames[c]
+}
+
+// RowUsage holds a row count per circuit.
+type RowUsage [numCircuits]uint64
+
+// keccakRate is the number of input bytes absorbed per keccak-f permutation.
+const keccakRate = 136
+
+// State circuit rows of the execution gadgets, on top of the stack items they
+// pop and push. They follow the rw operations the gadgets look up, as recorded
+// by the RwTracer.
+const (
+	// rwsTxScope are the call context reads of gadgets touching tx scoped
+	// state: tx id, rw counter end of reversion and is persistent.
+	rwsTxScope = 3
+	// rwsReversible is a reversible write plus the row undoing it, which
+	// is counted in case the frame fails.
+	rwsReversible = 2
+	// rwsFrameEntry is opening a frame: the pc, stack pointer, gas left,
+	// memory size and state write counter saved in the caller's call
+	// context, and the 17 call context fields of the callee.
+	rwsFrameEntry = 5 + 17
+	// rwsFrameExit is closing a frame: the caller state saved at entry read
+	// back, and the last callee id, return data offset and length of the
+	// caller written.
+	rwsFrameExit = 5 + 3
+
+	// SLOAD reads the callee address and the slot, and warms the slot up.
+	rwsSload = rwsTxScope + 1 + 1 + rwsReversible
+	// SSTORE reads the callee address and is static, and writes the slot,
+	// its warm-up and the refund.
+	rwsSstore = rwsTxScope + 2 + 3*rwsReversible
+	// TLOAD reads the tx id, the callee address and the slot.
+	rwsTload = 2 + 1
+	// TSTORE reads the callee address and is static, and writes the slot.
+	rwsTstore = rwsTxScope + 2 + rwsReversible
+	// BALANCE, EXTCODESIZE, EXTCODECOPY and EXTCODEHASH warm the account up
+	// and read its balance or code hash.
+	rwsAccountAccess = rwsTxScope + rwsReversible + 1
+	// SELFBALANCE reads the callee address and its balance.
+	rwsSelfBalance = 1 + 1
+	// LOG reads the callee address and is static, and writes the address
+	// of the log. Topics and data add a row each.
+	rwsLog = rwsTxScope + 2 + 1
+	// CALL family: the depth, is static, callee and caller addresses and
+	// value of the caller, the warm-up of the callee, its code hash and
+	// balance, the value transfer (two balances) and the frame switches.
+	rwsCall = rwsTxScope + 5 + rwsReversible + 2 + 2*rwsReversible + rwsFrameEntry + rwsFrameExit
+	// CREATE family: the depth, is static and callee address of the
+	// creator, its nonce bump, the warm-up and nonce of the new account,
+	// the value transfer (two balances), the code hash stored and the frame
+	// switches.
+	rwsCreate = rwsTxScope + 3 + 3*rwsReversible + 2*rwsReversible + rwsReversible + rwsFrameEntry + rwsFrameExit
+	// SELFDESTRUCT reads the callee address and is static, warms the
+	// beneficiary up, moves the balance (two balances) and marks the account
+	// destructed.
+	rwsSelfDestruct = rwsTxScope + 2 + rwsReversible + 2*rwsReversible + rwsReversible
+
+	// rwsBeginTx are the call context of the root frame, the sender nonce
+	// bump and gas purchase, the value transfer (two balances) and the code
+	// hash read of the callee. The access list warm-ups add a row each.
+	rwsBeginTx = 17 + 2 + 2*rwsReversible + 1
+	// rwsEndTx are the refund read, the sender refund and coinbase fee
+	// writes and the three receipt fields.
+	rwsEndTx = 1 + 2 + 3
+)
+
+// CircuitCapacityChecker estimates the rows a block uses in every circuit while
+// its transactions execute, and fails execution with ErrCircuitCapacityExceeded
+// once any circuit would go over its limit.
+//
+// Usage is accumulated per transaction and added to the block by CommitTx. Once
+// a transaction has overflowed, every further step of it fails as well, so the
+// whole transaction stops deterministically and is reverted, or rejected if
+// Reject is set. The checker is not thread safe.
+type CircuitCapacityChecker struct {
+	Limits RowUsage // Rows available in each circuit, zero meaning unlimited
+	Reject bool     // Reject transactions that overflow instead of reverting them
+
+	block    RowUsage
+	tx       RowUsage
+	exceeded bool
+
+	codes   map[common.Hash]struct{} // codes already in the bytecode circuit
+	txCodes map[common.Hash]struct{} // codes added by the current transaction
+}
+
+// NewCircuitCapacityChecker returns a checker for a block with the given
+// per-circuit limits.
+func NewCircuitCapacityChecker(limits RowUsage) *CircuitCapacityChecker {
+	return &CircuitCapacityChecker{
+		Limits:  limits,
+		codes:   make(map[common.Hash]struct{}),
+		txCodes: make(map[common.Hash]struct{}),
+	}
+}
+
+// Usage returns the rows used by the committed transactions of the block.
+func (c *CircuitCapacityChecker) Usage() RowUsage {
+	return c.block
+}
+
+// TxUsage returns the rows used by the transaction being executed.
+func (c *CircuitCapacityChecker) TxUsage() RowUsage {
+	return c.tx
+}
+
+// BeginTx starts accounting a new transaction, dropping the usage of a previous
+// transaction that was not committed.
+func (c *CircuitCapacityChecker) BeginTx() {
+	c.tx, c.exceeded = RowUsage{}, false
+	for hash := range c.txCodes {
+		delete(c.txCodes, hash)
+	}
+}
+
+// CommitTx adds the usage of the current transaction to the block.
+func (c *CircuitCapacityChecker) CommitTx() {
+	for i := range c.block {
+		c.block[i] += c.tx[i]
+	}
+	for hash := range c.txCodes {
+		c.codes[hash] = struct{}{}
+	}
+	c.BeginTx()
+}
+
+// Reset forgets the usage of the block, to start a new one.
+func (c *CircuitCapacityChecker) Reset() {
+	c.block = RowUsage{}
+	for hash := range c.codes {
+		delete(c.codes, hash)
+	}
+	c.BeginTx()
+}
+
+// add accounts rows to a circuit of the current transaction. Rows that don't
+// fit are not accounted, so the usage of a transaction stopped by the checker
+// stays within the limits.
+func (c *CircuitCapacityChecker) add(circuit Circuit, rows uint64) error {
+	if c.exceeded {
+		return ErrCircuitCapacityExceeded
+	}
+	if limit := c.Limits[circuit]; limit != 0 && c.block[circuit]+c.tx[circuit]+rows > limit {
+		c.exceeded = true
+		return ErrCircuitCapacityExceeded
+	}
+	c.tx[circuit] += rows
+	return nil
+}
+
+// addCode accounts a code for the bytecode circuit, once per block.
+func (c *CircuitCapacityChecker) addCode(hash common.Hash, size int) error {
+	var rows uint64
+	if _, ok := c.codes[hash]; !ok {
+		if _, ok := c.txCodes[hash]; !ok {
+			c.txCodes[hash] = struct{}{}
+			rows = uint64(size) + 1
+		}
+	}
+	return c.add(CircuitBytecode, rows)
+}
+
+// AddTx accounts the begin and end steps of a transaction, which warms up
+// accessListRows accounts and slots. Once it fails, the transaction must not
+// be executed.
+func (c *CircuitCapacityChecker) AddTx(accessListRows uint64) error {
+	if err := c.add(CircuitEVM, 2); err != nil {
+		return err
+	}
+	return c.add(CircuitState, rwsBeginTx+accessListRows+rwsEndTx)
+}
+
+// addStep accounts the rows used by the execution of op, whose gas has been
+// paid and memory expanded.
+func (c *CircuitCapacityChecker) addStep(op OpCode, operation *operation, stack *Stack) error {
+	if err := c.add(CircuitEVM, 1); err != nil {
+		return err
+	}
+	// Every stack item popped or pushed is an rw operation, as are the memory
+	// bytes and state accessed by the opcode.
+	var (
+		pops   = operation.minStack
+		pushes = int(params.StackLimit) + operation.minStack - operation.maxStack
+		rws    = uint64(pops + pushes)
+	)
+
+	var copied, hashed uint64
+	switch op {
+	case MLOAD, MSTORE:
+		rws += 32
+	case MSTORE8:
+		rws++
+	case SLOAD:
+		rws += rwsSload
+	case SSTORE:
+		rws += rwsSstore
+	case TLOAD:
+		rws += rwsTload
+	case TSTORE:
+		rws += rwsTstore
+	case BALANCE, EXTCODESIZE, EXTCODECOPY, EXTCODEHASH:
+		rws += rwsAccountAccess
+	case SELFBALANCE:
+		rws += rwsSelfBalance
+	case LOG0, LOG1, LOG2, LOG3, LOG4:
+		rws += rwsLog + uint64(op-LOG0)
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		rws += rwsCall
+	case CREATE, CREATE2:
+		rws += rwsCreate
+	case SELFDESTRUCT:
+		rws += rwsSelfDestruct
+	}
+	switch op {
+	case CALLDATACOPY, CODECOPY, RETURNDATACOPY, MCOPY:
+		copied = stack.Back(2).Uint64()
+	case EXTCODECOPY:
+		copied = stack.Back(3).Uint64()
+	case LOG0, LOG1, LOG2, LOG3, LOG4, RETURN, REVERT:
+		copied = stack.Back(1).Uint64()
+	case KECCAK256:
+		copied = stack.Back(1).Uint64()
+		hashed = copied/keccakRate + 1
+	case CREATE, CREATE2:
+		copied = stack.Back(2).Uint64()
+		hashed = copied/keccakRate + 2 // init code and address
+	}
+	// Bytes copied are read and written through the rw table as well.
+	rws += 2 * copied
+
+	if err := c.add(CircuitState, rws); err != nil {
+		return err
+	}
+	if err := c.add(CircuitCopy, copied); err != nil {
+		return err
+	}
+	return c.add(CircuitKeccak, hashed)
+}
+
+// addPrecompile accounts the rows used by a precompile call with the given
+// input and output: both are copied, from the memory of the caller and back
+// into it, each byte read and written through the rw table.
+func (c *CircuitCapacityChecker) addPrecompile(input, output []byte) error {
+	copied := uint64(len(input) + len(output))
+	if err := c.add(CircuitState, 2*copied); err != nil {
+		return err
+	}
+	return c.add(CircuitCopy, copied)
+}
+
+// addPrecompileRows accounts the rows of a precompile call to the circuit
+// capacity checker, if any.
+func (evm *EVM) addPrecompileRows(input, output []byte) error {
+	if checker := evm.Config.CircuitCapacityChecker; checker != nil {
+		return checker.addPrecompile(input, output)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// BlockContextFieldTag is the tag of a block table row, following the circuit's
+// BlockContextFieldTag.
+type BlockContextFieldTag uint64
+
+const (
+	BlockContextCoinbase BlockContextFieldTag = iota + 1
+	BlockContextTimestamp
+	BlockContextNumber
+	BlockContextDifficulty
+	BlockContextGasLimit
+	BlockContextBaseFee BlockContextFieldTag = iota + 3 // The circuit keeps the opcode order, skipping 6 and 7
+	BlockContextBlockHash
+	BlockContextChainID
+)
+
+// TxContextFieldTag is the tag of a tx table row, following the circuit's
+// TxContextFieldTag.
+type TxContextFieldTag uint64
+
+const (
+	TxContextNonce TxContextFieldTag = iota + 1
+	TxContextGas
+	TxContextGasPrice
+	TxContextCallerAddress
+	TxContextCalleeAddress
+	TxContextIsCreate
+	TxContextValue
+	TxContextCallDataLength
+	TxContextCallDataGasCost
+	TxContextCallData
+)
+
+// BlockContextRow is a row of the block table. Index is only set for BlockHash
+// rows, where it's the number of the hashed block.
+type BlockContextRow struct {
+	Tag   BlockContextFieldTag
+	Index uint64
+	Value common.Hash
+}
+
+// TxContextRow is a row of the tx table. Index is only set for CallData rows,
+// where it's the position of the byte in the calldata.
+type TxContextRow struct {
+	TxID  uint64
+	Tag   TxContextFieldTag
+	Index uint64
+	Value common.Hash
+}
+
+// TxFields holds the fields of a transaction the tx table commits to that the
+// EVM doesn't keep in its TxContext.
+type TxFields struct {
+	Nonce uint64
+	Gas   uint64
+	To    *common.Address // nil for contract creations
+	Value *big.Int
+	Data  []byte
+}
+
+// BlockContextTable returns the block table rows of the block the EVM runs in,
+// as seen by the block opcodes: the DIFFICULTY row holds the RANDOM value after
+// the merge, and the BlockHash rows cover the window BLOCKHASH can read.
+func (evm *EVM) BlockContextTable() []BlockContextRow {
+	var (
+		ctx    = evm.Context
+		number = ctx.BlockNumber.Uint64()
+	)
+	difficulty := bigToHash(ctx.Difficulty)
+	if evm.chainRules.IsMerge && ctx.Random != nil {
+		difficulty = *ctx.Random
+	}
+	rows := []BlockContextRow{
+		{Tag: BlockContextCoinbase, Value: common.BytesToHash(ctx.Coinbase.Bytes())},
+		{Tag: BlockContextTimestamp, Value: bigToHash(ctx.Time)},
+		{Tag: BlockContextNumber, Value: bigToHash(ctx.BlockNumber)},
+		{Tag: BlockContextDifficulty, Value: difficulty},
+		{Tag: BlockContextGasLimit, Value: uint64ToHash(ctx.GasLimit)},
+		{Tag: BlockContextBaseFee, Value: bigToHash(ctx.BaseFee)},
+	}
+	var lower uint64
+	if number > 256 {
+		lower = number - 256
+	}
+	for n := lower; n < number; n++ {
+		rows = append(rows, BlockContextRow{Tag: BlockContextBlockHash, Index: n, Value: ctx.GetHash(n)})
+	}
+	return append(rows, BlockContextRow{Tag: BlockContextChainID, Value: bigToHash(evm.chainConfig.ChainID)})
+}
+
+// TxContextTable returns the tx table rows of the transaction the EVM runs. The
+// tx id, caller address and gas price are taken from the TxContext of the EVM.
+func (evm *EVM) TxContextTable(tx TxFields) []TxContextRow {
+	txID := evm.TxContext.TxID
+	var callee common.Hash
+	if tx.To != nil {
+		callee = common.BytesToHash(tx.To.Bytes())
+	}
+	nonZeroGas := params.TxDataNonZeroGasFrontier
+	if evm.chainRules.IsIstanbul {
+		nonZeroGas = params.TxDataNonZeroGasEIP2028
+	}
+	var gasCost uint64
+	for _, b := range tx.Data {
+		if b == 0 {
+			gasCost += params.TxDataZeroGas
+		} else {
+			gasCost += nonZeroGas
+		}
+	}
+	rows := []TxContextRow{
+		{TxID: txID, Tag: TxContextNonce, Value: uint64ToHash(tx.Nonce)},
+		{TxID: txID, Tag: TxContextGas, Value: uint64ToHash(tx.Gas)},
+		{TxID: txID, Tag: TxContextGasPrice, Value: bigToHash(evm.TxContext.GasPrice)},
+		{TxID: txID, Tag: TxContextCallerAddress, Value: common.BytesToHash(evm.TxContext.Origin.Bytes())},
+		{TxID: txID, Tag: TxContextCalleeAddress, Value: callee},
+		{TxID: txID, Tag: TxContextIsCreate, Value: boolToHash(tx.To == nil)},
+		{TxID: txID, Tag: TxContextValue, Value: bigToHash(tx.Value)},
+		{TxID: txID, Tag: TxContextCallDataLength, Value: uint64ToHash(uint64(len(tx.Data)))},
+		{TxID: txID, Tag: TxContextCallDataGasCost, Value: uint64ToHash(gasCost)},
+	}
+	for i, b := range tx.Data {
+		rows = append(rows, TxContextRow{TxID: txID, Tag: TxContextCallData, Index: uint64(i), Value: uint64ToHash(uint64(b))})
+	}
+	return rows
+}
+
+// bigToHash converts a possibly nil big integer to a hash, nil being zero.
+func bigToHash(b *big.Int) common.Hash {
+	if b == nil {
+		return common.Hash{}
+	}
+	return common.BigToHash(b)
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto/bls12381"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Gas costs of the BLS12-381 precompiles, as of the final version of EIP-2537.
+const (
+	bls12381G1AddGasPrague          uint64 = 375
+	bls12381G1MulGasPrague          uint64 = 12000
+	bls12381G2AddGasPrague          uint64 = 600
+	bls12381G2MulGasPrague          uint64 = 22500
+	bls12381PairingBaseGasPrague    uint64 = 37700
+	bls12381PairingPerPairGasPrague uint64 = 32600
+	bls12381MapG1GasPrague          uint64 = 5500
+	bls12381MapG2GasPrague          uint64 = 23800
+)
+
+// bls12381G1MSMDiscountTable is the discount, in thousandths, applied to a G1
+// multi-scalar multiplication of k pairs, at index k-1.
+var bls12381G1MSMDiscountTable = [128]uint64{1000, 949, 848, 797, 764, 750, 738, 728, 719, 712, 705, 698, 692, 687, 682, 677, 673, 669, 665, 661, 658, 654, 651, 648, 645, 642, 640, 637, 635, 632, 630, 627, 625, 623, 621, 619, 617, 615, 613, 611, 609, 608, 606, 604, 603, 601, 599, 598, 596, 595, 593, 592, 591, 589, 588, 586, 585, 584, 582, 581, 580, 579, 577, 576, 575, 574, 573, 572, 570, 569, 568, 567, 566, 565, 564, 563, 562, 561, 560, 559, 558, 557, 556, 555, 554, 553, 552, 551, 550, 549, 548, 547, 547, 546, 545, 544, 543, 542, 541, 540, 540, 539, 538, 537, 536, 536, 535, 534, 533, 532, 532, 531, 530, 529, 528, 528, 527, 526, 525, 525, 524, 523, 522, 522, 521, 520, 520, 519}
+
+// bls12381G2MSMDiscountTable is the discount, in thousandths, applied to a G2
+// multi-scalar multiplication of k pairs, at index k-1.
+var bls12381G2MSMDiscountTable = [128]uint64{1000, 1000, 923, 884, 855, 832, 812, 796, 782, 770, 759, 749, 740, 732, 724, 717, 711, 704, 699, 693, 688, 683, 679, 674, 670, 666, 663, 659, 655, 652, 649, 646, 643, 640, 637, 634, 632, 629, 627, 624, 622, 620, 618, 615, 613, 611, 609, 607, 606, 604, 602, 600, 598, 597, 595, 593, 592, 590, 589, 587, 586, 584, 583, 582, 580, 579, 578, 576, 575, 574, 573, 571, 570, 569, 568, 567, 566, 565, 563, 562, 561, 560, 559, 558, 557, 556, 555, 554, 553, 552, 552, 551, 550, 549, 548, 547, 546, 545, 545, 544, 543, 542, 541, 541, 540, 539, 538, 537, 537, 536, 535, 535, 534, 533, 532, 532, 531, 530, 530, 529, 528, 528, 527, 526, 526, 525, 524, 524}
+
+// PrecompiledContractsPrague contains the default set of pre-compiled Ethereum
+// contracts used in the Prague release.
+var PrecompiledContractsPrague = map[common.Address]PrecompiledContract{
+	common.BytesToAddress([]byte{1}):    &ecrecover{},
+	common.BytesToAddress([]byte{2}):    &sha256hash{},
+	common.BytesToAddress([]byte{3}):    &ripemd160hash{},
+	common.BytesToAddress([]byte{4}):    &dataCopy{},
+	common.BytesToAddress([]byte{5}):    &bigModExp{eip2565: true},
+	common.BytesToAddress([]byte{6}):    &bn256AddIstanbul{},
+	common.BytesToAddress([]byte{7}):    &bn256ScalarMulIstanbul{},
+	common.BytesToAddress([]byte{8}):    &bn256PairingIstanbul{},
+	common.BytesToAddress([]byte{9}):    &blake2F{},
+	common.BytesToAddress([]byte{0x0a}): &kzgPointEvaluation{},
+	common.BytesToAddress([]byte{0x0b}): &bls12381G1AddPrague{},
+	common.BytesToAddress([]byte{0x0c}): &bls12381G1MSM{},
+	common.BytesToAddress([]byte{0x0d}): &bls12381G2AddPrague{},
+	common.BytesToAddress([]byte{0x0e}): &bls12381G2MSM{},
+	common.BytesToAddress([]byte{0x0f}): &bls12381PairingPrague{},
+	common.BytesToAddress([]byte{0x10}): &bls12381MapG1Prague{},
+	common.BytesToAddress([]byte{0x11}): &bls12381MapG2Prague{},
+}
+
+// PrecompiledAddressesPrague holds the addresses of PrecompiledContractsPrague,
+// in ascending order so that access lists are walked deterministically.
+var PrecompiledAddressesPrague = []common.Address{
+	common.BytesToAddress([]byte{1}),
+	common.BytesToAddress([]byte{2}),
+	common.BytesToAddress([]byte{3}),
+	common.BytesToAddress([]byte{4}),
+	common.BytesToAddress([]byte{5}),
+	common.BytesToAddress([]byte{6}),
+	common.BytesToAddress([]byte{7}),
+	common.BytesToAddress([]byte{8}),
+	common.BytesToAddress([]byte{9}),
+	common.BytesToAddress([]byte{0x0a}),
+	common.BytesToAddress([]byte{0x0b}),
+	common.BytesToAddress([]byte{0x0c}),
+	common.BytesToAddress([]byte{0x0d}),
+	common.BytesToAddress([]byte{0x0e}),
+	common.BytesToAddress([]byte{0x0f}),
+	common.BytesToAddress([]byte{0x10}),
+	common.BytesToAddress([]byte{0x11}),
+}
+
+// PrecompiledAddresses returns the precompiled contracts addresses of the
+// given rules, like ActivePrecompiles, including the forks after the merge.
+func PrecompiledAddresses(rules params.Rules) []common.Address {
+	switch {
+	case rules.IsPrague:
+		return PrecompiledAddressesPrague
+	case rules.IsCancun:
+		return PrecompiledAddressesCancun
+	default:
+		return ActivePrecompiles(rules)
+	}
+}
+
+// msmGas returns the gas cost of a multi-scalar multiplication of k pairs.
+func msmGas(k int, mulGas uint64, discounts []uint64) uint64 {
+	if k == 0 {
+		return 0
+	}
+	discount := discounts[len(discounts)-1]
+	if k <= len(discounts) {
+		discount = discounts[k-1]
+	}
+	return uint64(k) * mulGas * discount / 1000
+}
+
+// bls12381G1AddPrague implements the G1ADD precompile at its EIP-2537 price.
+type bls12381G1AddPrague struct{ bls12381G1Add }
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G1AddPrague) RequiredGas(input []byte) uint64 {
+	return bls12381G1AddGasPrague
+}
+
+// bls12381G2AddPrague implements the G2ADD precompile at its EIP-2537 price.
+type bls12381G2AddPrague struct{ bls12381G2Add }
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G2AddPrague) RequiredGas(input []byte) uint64 {
+	return bls12381G2AddGasPrague
+}
+
+// bls12381PairingPrague implements the PAIRING precompile at its EIP-2537 price.
+type bls12381PairingPrague struct{ bls12381Pairing }
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381PairingPrague) RequiredGas(input []byte) uint64 {
+	return bls12381PairingBaseGasPrague + uint64(len(input)/384)*bls12381PairingPerPairGasPrague
+}
+
+// bls12381MapG1Prague implements the MAP_FP_TO_G1 precompile at its EIP-2537
+// price.
+type bls12381MapG1Prague struct{ bls12381MapG1 }
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381MapG1Prague) RequiredGas(input []byte) uint64 {
+	return bls12381MapG1GasPrague
+}
+
+// bls12381MapG2Prague implements the MAP_FP2_TO_G2 precompile at its EIP-2537
+// price.
+type bls12381MapG2Prague struct{ bls12381MapG2 }
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381MapG2Prague) RequiredGas(input []byte) uint64 {
+	return bls12381MapG2GasPrague
+}
+
+// bls12381G1MSM implements the G1MSM precompile of EIP-2537, which replaces
+// G1MUL and G1MULTIEXP of the draft and requires points in the subgroup.
+type bls12381G1MSM struct{ bls12381G1MultiExp }
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G1MSM) RequiredGas(input []byte) uint64 {
+	return msmGas(len(input)/160, bls12381G1MulGasPrague, bls12381G1MSMDiscountTable[:])
+}
+
+func (c *bls12381G1MSM) Run(input []byte) ([]byte, error) {
+	if len(input) == 0 || len(input)%160 != 0 {
+		return nil, errBLS12381InvalidInputLength
+	}
+	g := bls12381.NewG1()
+	for i := 0; i < len(input); i += 160 {
+		p, err := g.DecodePoint(input[i : i+128])
+		if err != nil {
+			return nil, err
+		}
+		if !g.InCorrectSubgroup(p) {
+			return nil, errBLS12381G1PointSubgroup
+		}
+	}
+	return c.bls12381G1MultiExp.Run(input)
+}
+
+// bls12381G2MSM implements the G2MSM precompile of EIP-2537, which replaces
+// G2MUL and G2MULTIEXP of the draft and requires points in the subgroup.
+type bls12381G2MSM struct{ bls12381G2MultiExp }
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G2MSM) RequiredGas(input []byte) uint64 {
+	return msmGas(len(input)/288, bls12381G2MulGasPrague, bls12381G2MSMDiscountTable[:])
+}
+
+func (c *bls12381G2MSM) Run(input []byte) ([]byte, error) {
+	if len(input) == 0 || len(input)%288 != 0 {
+		return nil, errBLS12381InvalidInputLength
+	}
+	g := bls12381.NewG2()
+	for i := 0; i < len(input); i += 288 {
+		p, err := g.DecodePoint(input[i : i+256])
+		if err != nil {
+			return nil, err
+		}
+		if !g.InCorrectSubgroup(p) {
+			return nil, errBLS12381G2PointSubgroup
+		}
+	}
+	return c.bls12381G2MultiExp.Run(input)
+}
@@ -0,0 +1,175 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// CopyDataType is the kind of data a copy event reads from or writes to.
+type CopyDataType uint64
+
+const (
+	CopyDataMemory      CopyDataType = iota + 1 // memory of a call, ID is the call id
+	CopyDataBytecode                            // code, ID is the code hash
+	CopyDataTxCalldata                          // calldata of a transaction, ID is the tx id
+	CopyDataTxLog                               // data of a log, ID is the tx id, offset starts at 0 per log
+	CopyDataReturnData                          // return data of a call, ID is the call id of the callee
+	CopyDataKeccakInput                         // input of a keccak hash, ID is the hash
+)
+
+// CopyEvent is a bulk copy of bytes performed by the EVM, the input of the copy
+// circuit. Calldata of internal calls lives in the caller's memory, so it is
+// reported as a copy from the caller's memory at the call data offset.
+type CopyEvent struct {
+	SrcType   CopyDataType
+	SrcID     common.Hash
+	SrcOffset uint64
+
+	DstType   CopyDataType
+	DstID     common.Hash
+	DstOffset uint64
+
+	Length uint64
+	Bytes  []byte
+}
+
+// CopyEvents returns the copy events recorded since the EVM was created, if
+// Config.EnableCopyEventRecording is set.
+func (evm *EVM) CopyEvents() []*CopyEvent {
+	return evm.copyEvents
+}
+
+func (evm *EVM) recordCopyEvent(event *CopyEvent) {
+	event.Length = uint64(len(event.Bytes))
+	evm.copyEvents = append(evm.copyEvents, event)
+}
+
+// currentCallContext returns the call context of the frame being executed.
+func (evm *EVM) currentCallContext() *CallContext {
+	return evm.callStack[len(evm.callStack)-1]
+}
+
+// lastCallee returns the call context of the latest frame called by ctx.
+func (evm *EVM) lastCallee(ctx *CallContext) *CallContext {
+	for i := len(evm.callContexts) - 1; i >= 0; i-- {
+		if evm.callContexts[i].parent == ctx {
+			return evm.callContexts[i]
+		}
+	}
+	return nil
+}
+
+// prepareCopyEvent starts the copy event of op, before the operation executes.
+// Copies whose bytes are only known after the execution are completed by
+// finishCopyEvent. Copies made when a frame is entered or exited are recorded
+// by the EVM at that point.
+func (in *EVMInterpreter) prepareCopyEvent(op OpCode, scope *ScopeContext) *CopyEvent {
+	var (
+		stack  = scope.Stack
+		ctx    = in.evm.currentCallContext()
+		callID = uint64ToHash(ctx.ID)
+	)
+	switch op {
+	case CALLDATACOPY:
+		event := &CopyEvent{DstType: CopyDataMemory, DstID: callID, DstOffset: stack.Back(0).Uint64(), SrcOffset: stack.Back(1).Uint64(), Length: stack.Back(2).Uint64()}
+		if ctx.IsRoot {
+			event.SrcType, event.SrcID = CopyDataTxCalldata, uint64ToHash(ctx.TxID)
+		} else {
+			event.SrcType, event.SrcID = CopyDataMemory, uint64ToHash(ctx.CallerID)
+			event.SrcOffset += ctx.CallDataOffset
+		}
+		return event
+
+	case CODECOPY:
+		return &CopyEvent{SrcType: CopyDataBytecode, SrcID: scope.Contract.CodeHash, SrcOffset: stack.Back(1).Uint64(),
+			DstType: CopyDataMemory, DstID: callID, DstOffset: stack.Back(0).Uint64(), Length: stack.Back(2).Uint64()}
+
+	case EXTCODECOPY:
+		address := common.Address(stack.Back(0).Bytes20())
+		return &CopyEvent{SrcType: CopyDataBytecode, SrcID: in.evm.StateDB.GetCodeHash(address), SrcOffset: stack.Back(2).Uint64(),
+			DstType: CopyDataMemory, DstID: callID, DstOffset: stack.Back(1).Uint64(), Length: stack.Back(3).Uint64()}
+
+	case MCOPY:
+		return &CopyEvent{SrcType: CopyDataMemory, SrcID: callID, SrcOffset: stack.Back(1).Uint64(),
+			DstType: CopyDataMemory, DstID: callID, DstOffset: stack.Back(0).Uint64(), Length: stack.Back(2).Uint64()}
+
+	case RETURNDATACOPY:
+		var calleeID common.Hash
+		if callee := in.evm.lastCallee(ctx); callee != nil {
+			calleeID = uint64ToHash(callee.ID)
+		}
+		return &CopyEvent{SrcType: CopyDataReturnData, SrcID: calleeID, SrcOffset: stack.Back(1).Uint64(),
+			DstType: CopyDataMemory, DstID: callID, DstOffset: stack.Back(0).Uint64(), Length: stack.Back(2).Uint64()}
+
+	case LOG0, LOG1, LOG2, LOG3, LOG4:
+		offset, size := stack.Back(0).Uint64(), stack.Back(1).Uint64()
+		return &CopyEvent{SrcType: CopyDataMemory, SrcID: callID, SrcOffset: offset,
+			DstType: CopyDataTxLog, DstID: uint64ToHash(ctx.TxID), Bytes: scope.Memory.GetCopy(int64(offset), int64(size))}
+
+	case KECCAK256:
+		offset, size := stack.Back(0).Uint64(), stack.Back(1).Uint64()
+		data := scope.Memory.GetCopy(int64(offset), int64(size))
+		return &CopyEvent{SrcType: CopyDataMemory, SrcID: callID, SrcOffset: offset,
+			DstType: CopyDataKeccakInput, DstID: crypto.Keccak256Hash(data), Bytes: data}
+
+	case RETURN:
+		if !ctx.IsCreate {
+			// Returns of calls are copied as the frame exits, see
+			// recordReturnDataCopy.
+			return nil
+		}
+		offset, size := stack.Back(0).Uint64(), stack.Back(1).Uint64()
+		code := scope.Memory.GetCopy(int64(offset), int64(size))
+		return &CopyEvent{SrcType: CopyDataMemory, SrcID: callID, SrcOffset: offset,
+			DstType: CopyDataBytecode, DstID: crypto.Keccak256Hash(code), Bytes: code}
+	}
+	// The init code of creations is copied as their frame is entered, see
+	// create.
+	return nil
+}
+
+// finishCopyEvent completes and records the copy event of op once the
+// operation has executed successfully. Until then, Length holds the size of the
+// destination area.
+func (in *EVMInterpreter) finishCopyEvent(op OpCode, event *CopyEvent, scope *ScopeContext) {
+	switch op {
+	case CALLDATACOPY, CODECOPY, EXTCODECOPY, RETURNDATACOPY, MCOPY:
+		// The copied bytes are what landed in memory, zero padded past the
+		// end of the source.
+		event.Bytes = scope.Memory.GetCopy(int64(event.DstOffset), int64(event.Length))
+	}
+	in.evm.recordCopyEvent(event)
+}
+
+// recordReturnDataCopy records the copy of the data returned by the frame of
+// ctx into the memory of its caller as the frame exits, as much of it as fits
+// the area the caller reserved for it.
+func (evm *EVM) recordReturnDataCopy(ctx *CallContext, ret []byte) {
+	if ctx.IsRoot || ctx.IsCreate {
+		return
+	}
+	if uint64(len(ret)) > ctx.ReturnDataLength {
+		ret = ret[:ctx.ReturnDataLength]
+	}
+	if len(ret) == 0 {
+		return
+	}
+	evm.recordCopyEvent(&CopyEvent{SrcType: CopyDataReturnData, SrcID: uint64ToHash(ctx.ID),
+		DstType: CopyDataMemory, DstID: uint64ToHash(ctx.CallerID), DstOffset: ctx.ReturnDataOffset, Bytes: common.CopyBytes(ret)})
+}
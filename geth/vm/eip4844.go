@@ -0,0 +1,246 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+
+	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// Opcodes of EIP-4844 and EIP-7516.
+const (
+	BLOBHASH    OpCode = 0x49
+	BLOBBASEFEE OpCode = 0x4a
+)
+
+func init() {
+	for op, name := range map[OpCode]string{
+		BLOBHASH:    "BLOBHASH",
+		BLOBBASEFEE: "BLOBBASEFEE",
+	} {
+		opCodeToString[op] = name
+		stringToOp[name] = op
+	}
+	activators[4844] = enable4844
+	activators[7516] = enable7516
+}
+
+// enable4844 applies EIP-4844 (BLOBHASH opcode).
+func enable4844(jt *JumpTable) {
+	jt[BLOBHASH] = &operation{
+		execute:     opBlobHash,
+		constantGas: GasFastestStep,
+		minStack:    minStack(1, 1),
+		maxStack:    maxStack(1, 1),
+	}
+}
+
+// opBlobHash implements the BLOBHASH opcode.
+func opBlobHash(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	index := scope.Stack.peek()
+	if hashes := interpreter.evm.TxContext.BlobHashes; index.LtUint64(uint64(len(hashes))) {
+		index.SetBytes(hashes[index.Uint64()].Bytes())
+	} else {
+		index.Clear()
+	}
+	return nil, nil
+}
+
+// enable7516 applies EIP-7516 (BLOBBASEFEE opcode).
+func enable7516(jt *JumpTable) {
+	jt[BLOBBASEFEE] = &operation{
+		execute:     opBlobBaseFee,
+		constantGas: GasQuickStep,
+		minStack:    minStack(0, 1),
+		maxStack:    maxStack(0, 1),
+	}
+}
+
+// opBlobBaseFee implements the BLOBBASEFEE opcode.
+func opBlobBaseFee(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	fee := new(uint256.Int)
+	if blobBaseFee := interpreter.evm.Context.BlobBaseFee(); blobBaseFee != nil {
+		fee.SetFromBig(blobBaseFee)
+	}
+	scope.Stack.push(fee)
+	return nil, nil
+}
+
+// BlobBaseFee returns the price of a unit of blob gas in the block, nil if the
+// block has no excess blob gas, i.e. is before cancun.
+func (ctx *BlockContext) BlobBaseFee() *big.Int {
+	if ctx.ExcessBlobGas == nil {
+		return nil
+	}
+	return CalcBlobFee(*ctx.ExcessBlobGas)
+}
+
+// CalcBlobFee calculates the blob base fee from the excess blob gas of a block.
+func CalcBlobFee(excessBlobGas uint64) *big.Int {
+	return fakeExponential(
+		big.NewInt(params.BlobTxMinBlobGasprice),
+		new(big.Int).SetUint64(excessBlobGas),
+		big.NewInt(params.BlobTxBlobGaspriceUpdateFraction),
+	)
+}
+
+// fakeExponential approximates factor * e ** (numerator / denominator) using
+// Taylor expansion.
+func fakeExponential(factor, numerator, denominator *big.Int) *big.Int {
+	var (
+		output = new(big.Int)
+		accum  = new(big.Int).Mul(factor, denominator)
+	)
+	for i := 1; accum.Sign() > 0; i++ {
+		output.Add(output, accum)
+
+		accum.Mul(accum, numerator)
+		accum.Div(accum, denominator)
+		accum.Div(accum, big.NewInt(int64(i)))
+	}
+	return output.Div(output, denominator)
+}
+
+const (
+	blobVerifyInputLength           = 192  // Max input length for the point evaluation precompile.
+	blobCommitmentVersionKZG  uint8 = 0x01 // Version byte for the point evaluation precompile.
+	blobPrecompileReturnValue       = "000000000000000000000000000000000000000000000000000000000000100073eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001"
+)
+
+var (
+	errBlobVerifyInvalidInputLength = errors.New("invalid input length")
+	errBlobVerifyMismatchedVersion  = errors.New("mismatched versioned hash")
+	errBlobVerifyKZGProof           = errors.New("error verifying kzg proof")
+	errBlobVerifyNoTrustedSetup     = errors.New("kzg trusted setup not loaded")
+)
+
+var (
+	kzgContextLock sync.RWMutex
+	kzgContext     *gokzg4844.Context
+)
+
+// SetKZGTrustedSetup loads the KZG trusted setup the point evaluation
+// precompile verifies proofs against, from a JSON file in the format of the
+// consensus specs (g1_lagrange, g1_monomial and g2_monomial points). Until it
+// is loaded, every call to the precompile fails.
+func SetKZGTrustedSetup(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	setup := new(gokzg4844.JSONTrustedSetup)
+	if err := json.Unmarshal(data, setup); err != nil {
+		return fmt.Errorf("invalid kzg trusted setup %s: %w", path, err)
+	}
+	context, err := gokzg4844.NewContext4096(setup)
+	if err != nil {
+		return fmt.Errorf("invalid kzg trusted setup %s: %w", path, err)
+	}
+	kzgContextLock.Lock()
+	kzgContext = context
+	kzgContextLock.Unlock()
+	return nil
+}
+
+// KZGToVersionedHash returns the versioned hash of a KZG commitment, as found
+// in the blob hashes of a transaction.
+func KZGToVersionedHash(commitment gokzg4844.KZGCommitment) common.Hash {
+	h := sha256.Sum256(commitment[:])
+	h[0] = blobCommitmentVersionKZG
+	return h
+}
+
+// kzgPointEvaluation implements the EIP-4844 point evaluation precompile.
+type kzgPointEvaluation struct{}
+
+// RequiredGas estimates the gas required for running the point evaluation precompile.
+func (b *kzgPointEvaluation) RequiredGas(input []byte) uint64 {
+	return params.BlobTxPointEvaluationPrecompileGas
+}
+
+// Run executes the point evaluation precompile.
+func (b *kzgPointEvaluation) Run(input []byte) ([]byte, error) {
+	if len(input) != blobVerifyInputLength {
+		return nil, errBlobVerifyInvalidInputLength
+	}
+	var (
+		versionedHash common.Hash
+		point         gokzg4844.Scalar
+		claim         gokzg4844.Scalar
+		commitment    gokzg4844.KZGCommitment
+		proof         gokzg4844.KZGProof
+	)
+	copy(versionedHash[:], input[:32]) // versioned hash: first 32 bytes
+	copy(point[:], input[32:64])       // evaluation point: next 32 bytes
+	copy(claim[:], input[64:96])       // expected output: next 32 bytes
+	copy(commitment[:], input[96:144]) // input kzg point: next 48 bytes
+	copy(proof[:], input[144:192])     // proof: last 48 bytes
+
+	if KZGToVersionedHash(commitment) != versionedHash {
+		return nil, errBlobVerifyMismatchedVersion
+	}
+	kzgContextLock.RLock()
+	context := kzgContext
+	kzgContextLock.RUnlock()
+	if context == nil {
+		return nil, errBlobVerifyNoTrustedSetup
+	}
+	if err := context.VerifyKZGProof(commitment, point, claim, proof); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBlobVerifyKZGProof, err)
+	}
+	return common.Hex2Bytes(blobPrecompileReturnValue), nil
+}
+
+// PrecompiledContractsCancun contains the default set of pre-compiled Ethereum
+// contracts used in the Cancun release.
+var PrecompiledContractsCancun = map[common.Address]PrecompiledContract{
+	common.BytesToAddress([]byte{1}):    &ecrecover{},
+	common.BytesToAddress([]byte{2}):    &sha256hash{},
+	common.BytesToAddress([]byte{3}):    &ripemd160hash{},
+	common.BytesToAddress([]byte{4}):    &dataCopy{},
+	common.BytesToAddress([]byte{5}):    &bigModExp{eip2565: true},
+	common.BytesToAddress([]byte{6}):    &bn256AddIstanbul{},
+	common.BytesToAddress([]byte{7}):    &bn256ScalarMulIstanbul{},
+	common.BytesToAddress([]byte{8}):    &bn256PairingIstanbul{},
+	common.BytesToAddress([]byte{9}):    &blake2F{},
+	common.BytesToAddress([]byte{0x0a}): &kzgPointEvaluation{},
+}
+
+// PrecompiledAddressesCancun holds the addresses of PrecompiledContractsCancun,
+// in ascending order so that access lists are walked deterministically.
+var PrecompiledAddressesCancun = []common.Address{
+	common.BytesToAddress([]byte{1}),
+	common.BytesToAddress([]byte{2}),
+	common.BytesToAddress([]byte{3}),
+	common.BytesToAddress([]byte{4}),
+	common.BytesToAddress([]byte{5}),
+	common.BytesToAddress([]byte{6}),
+	common.BytesToAddress([]byte{7}),
+	common.BytesToAddress([]byte{8}),
+	common.BytesToAddress([]byte{9}),
+	common.BytesToAddress([]byte{0x0a}),
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// DelegationPrefix is the prefix of the code of an account delegating its
+// execution to another one, as set by an EIP-7702 authorization.
+var DelegationPrefix = []byte{0xef, 0x01, 0x00}
+
+// ParseDelegation returns the address code delegates to, if it is a
+// delegation designator.
+func ParseDelegation(code []byte) (common.Address, bool) {
+	if len(code) != len(DelegationPrefix)+common.AddressLength || !bytes.HasPrefix(code, DelegationPrefix) {
+		return common.Address{}, false
+	}
+	return common.BytesToAddress(code[len(DelegationPrefix):]), true
+}
+
+// AddressToDelegation returns the delegation designator of addr.
+func AddressToDelegation(addr common.Address) []byte {
+	return append(common.CopyBytes(DelegationPrefix), addr.Bytes()...)
+}
+
+func init() {
+	activators[7702] = enable7702
+}
+
+// enable7702 applies EIP-7702 (Set EOA account code): calls follow the
+// delegation designator, charging the access to the account delegated to. The
+// EXTCODE* instructions are left alone and see the designator itself, as the
+// final version of the EIP specifies.
+func enable7702(jt *JumpTable) {
+	// Replace rather than modify the operations, ExtraEips share them with
+	// the default instruction sets.
+	for _, op := range []OpCode{CALL, CALLCODE, DELEGATECALL, STATICCALL} {
+		operation := *jt[op]
+		operation.dynamicGas = makeDelegationGas(operation.dynamicGas)
+		jt[op] = &operation
+	}
+}
+
+// makeDelegationGas wraps the gas function of a call, adding the access to the
+// account the callee delegates to, if any. The access is charged before the
+// wrapped function runs, so that the gas passed on by the call accounts for it.
+func makeDelegationGas(oldCalculator gasFunc) gasFunc {
+	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+		target, ok := evm.delegation(stack.Back(1).Bytes20())
+		if !ok {
+			return oldCalculator(evm, contract, stack, mem, memorySize)
+		}
+		cost := params.WarmStorageReadCostEIP2929
+		if !evm.StateDB.AddressInAccessList(target) {
+			evm.StateDB.AddAddressToAccessList(target)
+			cost = params.ColdAccountAccessCostEIP2929
+		}
+		if !contract.UseGas(cost) {
+			return 0, ErrOutOfGas
+		}
+		gas, err := oldCalculator(evm, contract, stack, mem, memorySize)
+		contract.Gas += cost
+		if err != nil {
+			return 0, err
+		}
+		if gas, overflow := math.SafeAdd(gas, cost); !overflow {
+			return gas, nil
+		}
+		return 0, ErrGasUintOverflow
+	}
+}
+
+// delegation returns the account addr delegates to. Delegations are only
+// honoured as of prague.
+func (evm *EVM) delegation(addr common.Address) (common.Address, bool) {
+	if !evm.chainRules.IsPrague {
+		return common.Address{}, false
+	}
+	return ParseDelegation(evm.StateDB.GetCode(addr))
+}
+
+// resolveCode returns the code executed when calling addr: the code of the
+// account it delegates to, if any, its own code otherwise. Delegations are
+// followed once, never chained.
+func (evm *EVM) resolveCode(addr common.Address) []byte {
+	if target, ok := evm.delegation(addr); ok {
+		return evm.StateDB.GetCode(target)
+	}
+	return evm.StateDB.GetCode(addr)
+}
+
+// resolveCodeHash returns the hash of the code resolveCode returns.
+func (evm *EVM) resolveCodeHash(addr common.Address) common.Hash {
+	if target, ok := evm.delegation(addr); ok {
+		// The account delegated to may not exist, its code is empty then
+		if hash := evm.StateDB.GetCodeHash(target); hash != (common.Hash{}) {
+			return hash
+		}
+		return emptyCodeHash
+	}
+	return evm.StateDB.GetCodeHash(addr)
+}
@@ -0,0 +1,328 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package errcorpus generates minimal transactions triggering every error of
+// the EVM, to be used as a regression corpus for the error gadgets of the
+// zkEVM circuits.
+package errcorpus
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	// Sender is the account sending the transaction of every case.
+	Sender = common.HexToAddress("0x1000000000000000000000000000000000000000")
+	// Contract is the account called by the transaction of call cases.
+	Contract = common.HexToAddress("0x2000000000000000000000000000000000000000")
+	// Other is a second contract, for cases needing a nested call.
+	Other = common.HexToAddress("0x3000000000000000000000000000000000000000")
+)
+
+// Case is a minimal transaction triggering an EVM error.
+//
+// If Create is set the transaction creates a contract with Code as init code,
+// otherwise it calls Contract, whose code is Code, with Input as calldata.
+// Prestate holds any further account needed, Sender is funded with Value
+// unless Prestate overrides it.
+type Case struct {
+	Name     string
+	Code     []byte
+	Input    []byte
+	Gas      uint64
+	Value    *big.Int
+	Create   bool
+	Prestate core.GenesisAlloc
+	Config   func() vm.Config       // Extra EVM configuration, nil for none
+	Context  func() context.Context // Context the call runs with, nil for none
+
+	// Expect is the error the case triggers: a sentinel error, or a value of
+	// the error type, in which case the cause of an ErrOutOfGasKind has to
+	// match as well.
+	Expect error
+	// Depth is the depth of the frame the error occurs in, 1 for the frame
+	// of the transaction and 0 if the transaction fails before any frame is
+	// entered.
+	Depth int
+	// Soft is set if the error only makes a CALL or CREATE fail without
+	// failing the frame executing it, like ErrDepth.
+	Soft bool
+	// AllGas is set if the transaction consumes all of its gas.
+	AllGas bool
+}
+
+// Corpus returns a case for every error of the EVM and every cause of
+// ErrOutOfGasKind.
+func Corpus() []*Case {
+	return append(outOfGasCases(), errorCases()...)
+}
+
+func push1(v byte) []byte { return []byte{byte(vm.PUSH1), v} }
+
+func program(parts ...interface{}) []byte {
+	var code []byte
+	for _, part := range parts {
+		switch p := part.(type) {
+		case vm.OpCode:
+			code = append(code, byte(p))
+		case []byte:
+			code = append(code, p...)
+		}
+	}
+	return code
+}
+
+func outOfGasCases() []*Case {
+	oog := func(cause vm.OutOfGasCause) error { return &vm.ErrOutOfGasKind{Cause: cause} }
+	return []*Case{
+		{
+			Name:   "OutOfGasConstant",
+			Code:   push1(1),
+			Gas:    2,
+			Expect: oog(vm.OutOfGasConstant), Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "OutOfGasMemoryExpansion",
+			Code:   program(push1(0), []byte{byte(vm.PUSH4), 0x00, 0xff, 0xff, 0xff}, vm.MSTORE),
+			Gas:    9 + 100,
+			Expect: oog(vm.OutOfGasMemoryExpansion), Depth: 1, AllGas: true,
+		},
+		{
+			// Cold account access of the callee.
+			Name:   "OutOfGasCall",
+			Code:   program(push1(0), push1(0), push1(0), push1(0), push1(0), push1(0xff), push1(0), vm.CALL),
+			Gas:    21 + 100 + 1000,
+			Expect: oog(vm.OutOfGasCall), Depth: 1, AllGas: true,
+		},
+		{
+			// Enough gas for the reentrancy sentry, not for setting the slot.
+			Name:   "OutOfGasSloadSstore",
+			Code:   program(push1(1), push1(0), vm.SSTORE),
+			Gas:    6 + 2400,
+			Expect: oog(vm.OutOfGasSloadSstore), Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "OutOfGasExp",
+			Code:   program([]byte{byte(vm.PUSH2), 0xff, 0xff}, push1(2), vm.EXP),
+			Gas:    16 + 50,
+			Expect: oog(vm.OutOfGasExp), Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "OutOfGasSha3",
+			Code:   program(push1(0x40), push1(0), vm.KECCAK256),
+			Gas:    36 + 10,
+			Expect: oog(vm.OutOfGasSha3), Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "OutOfGasCopy",
+			Code:   program(push1(0x40), push1(0), push1(0), vm.CALLDATACOPY),
+			Gas:    12 + 5,
+			Expect: oog(vm.OutOfGasCopy), Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "OutOfGasLog",
+			Code:   program(push1(0x20), push1(0), vm.LOG0),
+			Gas:    6 + 100,
+			Expect: oog(vm.OutOfGasLog), Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "OutOfGasAccountAccess",
+			Code:   program(push1(0xff), vm.BALANCE),
+			Gas:    103 + 1000,
+			Expect: oog(vm.OutOfGasAccountAccess), Depth: 1, AllGas: true,
+		},
+		{
+			// Memory expansion for the init code, past the constant gas.
+			Name:   "OutOfGasCreate",
+			Code:   program([]byte{byte(vm.PUSH4), 0x00, 0xff, 0xff, 0xff}, push1(0), push1(0), vm.CREATE),
+			Gas:    9 + 32000 + 100,
+			Expect: oog(vm.OutOfGasCreate), Depth: 1, AllGas: true,
+		},
+		{
+			// Cold account access of the beneficiary.
+			Name:   "OutOfGasSelfDestruct",
+			Code:   program(push1(0xff), vm.SELFDESTRUCT),
+			Gas:    5003 + 1000,
+			Expect: oog(vm.OutOfGasSelfDestruct), Depth: 1, AllGas: true,
+		},
+	}
+}
+
+func errorCases() []*Case {
+	var (
+		overflow  = bytes.Repeat(push1(0), 1025)
+		recursive = program(push1(0), push1(0), push1(0), push1(0), push1(0), vm.ADDRESS, vm.GAS, vm.CALL)
+		static    = program(push1(0), push1(0), push1(0), push1(0), []byte{byte(vm.PUSH20)}, Other.Bytes(), vm.GAS, vm.STATICCALL, vm.STOP)
+		collision = crypto.CreateAddress(Sender, 0)
+	)
+	return []*Case{
+		{
+			Name:   "StackUnderflow",
+			Code:   program(vm.ADD),
+			Gas:    100,
+			Expect: &vm.ErrStackUnderflow{}, Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "StackOverflow",
+			Code:   overflow,
+			Gas:    1025*3 + 100,
+			Expect: &vm.ErrStackOverflow{}, Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "InvalidOpCode",
+			Code:   program(vm.INVALID),
+			Gas:    100,
+			Expect: &vm.ErrInvalidOpCode{}, Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "InvalidJump",
+			Code:   program(push1(0), vm.JUMP),
+			Gas:    100,
+			Expect: vm.ErrInvalidJump, Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "ReturnDataOutOfBounds",
+			Code:   program(push1(1), push1(0), push1(0), vm.RETURNDATACOPY),
+			Gas:    100,
+			Expect: vm.ErrReturnDataOutOfBounds, Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "GasUintOverflow",
+			Code:   program(push1(0), []byte{byte(vm.PUSH8), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, vm.MSTORE),
+			Gas:    100,
+			Expect: vm.ErrGasUintOverflow, Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "ExecutionReverted",
+			Code:   program(push1(0), push1(0), vm.REVERT),
+			Gas:    100,
+			Expect: vm.ErrExecutionReverted, Depth: 1,
+		},
+		{
+			Name:     "WriteProtection",
+			Code:     static,
+			Gas:      100000,
+			Prestate: core.GenesisAlloc{Other: {Code: program(push1(1), push1(0), vm.SSTORE), Balance: new(big.Int)}},
+			Expect:   vm.ErrWriteProtection, Depth: 2,
+		},
+		{
+			// Every frame forwards all it can to the next, the 63/64 rule
+			// needs a lot of gas to get 1024 frames deep.
+			Name:   "Depth",
+			Code:   recursive,
+			Gas:    10_000_000_000_000,
+			Expect: vm.ErrDepth, Depth: 1025, Soft: true,
+		},
+		{
+			Name:  "InsufficientBalance",
+			Code:  program(vm.STOP),
+			Gas:   100,
+			Value: big.NewInt(1),
+			// The sender can't afford the value it sends.
+			Prestate: core.GenesisAlloc{Sender: {Balance: new(big.Int)}},
+			Expect:   vm.ErrInsufficientBalance,
+		},
+		{
+			Name:     "ContractAddressCollision",
+			Code:     program(vm.STOP),
+			Gas:      100,
+			Create:   true,
+			Prestate: core.GenesisAlloc{collision: {Code: program(vm.STOP), Balance: new(big.Int)}},
+			Expect:   vm.ErrContractAddressCollision, AllGas: true,
+		},
+		{
+			Name:     "NonceUintOverflow",
+			Code:     program(vm.STOP),
+			Gas:      100,
+			Create:   true,
+			Prestate: core.GenesisAlloc{Sender: {Nonce: math.MaxUint64, Balance: new(big.Int)}},
+			Expect:   vm.ErrNonceUintOverflow,
+		},
+		{
+			// 32 bytes of code cost 6400 gas to deposit.
+			Name:   "CodeStoreOutOfGas",
+			Code:   program(push1(0x20), push1(0), vm.RETURN),
+			Gas:    1000,
+			Create: true,
+			Expect: vm.ErrCodeStoreOutOfGas, Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "MaxCodeSizeExceeded",
+			Code:   program([]byte{byte(vm.PUSH2), 0x60, 0x01}, push1(0), vm.RETURN),
+			Gas:    10000,
+			Create: true,
+			Expect: vm.ErrMaxCodeSizeExceeded, Depth: 1, AllGas: true,
+		},
+		{
+			Name:   "InvalidCode",
+			Code:   program(push1(0xef), push1(0), vm.MSTORE8, push1(1), push1(0), vm.RETURN),
+			Gas:    1000,
+			Create: true,
+			Expect: vm.ErrInvalidCode, Depth: 1, AllGas: true,
+		},
+		{
+			Name: "MaxInitCodeSizeExceeded",
+			Code: program([]byte{byte(vm.PUSH2), 0xc0, 0x01}, push1(0), push1(0), vm.CREATE),
+			Gas:  100000,
+			Config: func() vm.Config {
+				return vm.Config{ExtraEips: []int{3860}}
+			},
+			Expect: vm.ErrMaxInitCodeSizeExceeded, Depth: 1, AllGas: true,
+		},
+		{
+			Name: "CircuitCapacityExceeded",
+			Code: program(push1(0), push1(0), vm.ADD),
+			Gas:  100,
+			Config: func() vm.Config {
+				var limits vm.RowUsage
+				limits[vm.CircuitEVM] = 1
+				return vm.Config{CircuitCapacityChecker: vm.NewCircuitCapacityChecker(limits)}
+			},
+			Expect: vm.ErrCircuitCapacityExceeded, Depth: 1, AllGas: true,
+		},
+		{
+			Name: "UnsupportedOpCode",
+			Code: program(push1(0), vm.SELFDESTRUCT),
+			Gas:  10000,
+			Config: func() vm.Config {
+				unsupported := new(vm.Unsupported)
+				unsupported.Ops[vm.SELFDESTRUCT] = true
+				return vm.Config{Unsupported: unsupported}
+			},
+			Expect: &vm.ErrUnsupportedOpCode{}, Depth: 1, AllGas: true,
+		},
+		{
+			// The frame is aborted as it's entered, keeping its gas.
+			Name: "ExecutionAborted",
+			Code: program(vm.STOP),
+			Gas:  100,
+			Context: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			Expect: vm.ErrExecutionAborted, Depth: 1,
+		},
+	}
+}
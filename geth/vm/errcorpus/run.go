@@ -0,0 +1,255 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package errcorpus
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Result is the outcome of running a Case.
+type Result struct {
+	Err     error  // First error the transaction ran into, nil if none
+	Depth   int    // Depth of the frame Err occurred in
+	Soft    bool   // Whether Err only failed a CALL or CREATE
+	GasLeft uint64 // Gas left at the end of the transaction
+}
+
+// Alloc returns the state the case runs on: the funded sender, the contract
+// unless the case is a creation, and the accounts of Prestate.
+func (c *Case) Alloc() core.GenesisAlloc {
+	value := c.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	alloc := core.GenesisAlloc{Sender: {Balance: new(big.Int).Set(value)}}
+	if !c.Create {
+		alloc[Contract] = core.GenesisAccount{Code: c.Code, Balance: new(big.Int)}
+	}
+	for addr, account := range c.Prestate {
+		alloc[addr] = account
+	}
+	return alloc
+}
+
+// Run executes the case on a fresh in-memory state, with the rules of the
+// latest fork.
+func (c *Case) Run() (*Result, error) {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		return nil, err
+	}
+	for addr, account := range c.Alloc() {
+		statedb.SetBalance(addr, account.Balance)
+		statedb.SetNonce(addr, account.Nonce)
+		statedb.SetCode(addr, account.Code)
+		for key, value := range account.Storage {
+			statedb.SetState(addr, key, value)
+		}
+	}
+	var (
+		tracer   = new(errorTracer)
+		cfg      vm.Config
+		blockCtx = vm.BlockContext{
+			CanTransfer: core.CanTransfer,
+			Transfer:    core.Transfer,
+			GetHash:     func(uint64) common.Hash { return common.Hash{} },
+			BlockNumber: big.NewInt(1),
+			Time:        big.NewInt(1),
+			Difficulty:  big.NewInt(1),
+			GasLimit:    c.Gas,
+			BaseFee:     new(big.Int),
+		}
+		txCtx = vm.TxContext{Origin: Sender, GasPrice: new(big.Int)}
+	)
+	if c.Config != nil {
+		cfg = c.Config()
+	}
+	cfg.Debug, cfg.Tracer = true, tracer
+
+	var (
+		evm   = vm.NewEVM(blockCtx, txCtx, statedb, params.AllEthashProtocolChanges, cfg)
+		rules = evm.ChainConfig().Rules(blockCtx.BlockNumber, blockCtx.Random != nil, blockCtx.Time.Uint64())
+		value = c.Value
+		left  uint64
+	)
+	if value == nil {
+		value = new(big.Int)
+	}
+	if c.Create {
+		statedb.PrepareAccessList(Sender, nil, vm.PrecompiledAddresses(rules), nil)
+		_, _, left, err = evm.Create(vm.AccountRef(Sender), c.Code, c.Gas, value)
+	} else {
+		to := Contract
+		statedb.PrepareAccessList(Sender, &to, vm.PrecompiledAddresses(rules), nil)
+		if c.Context != nil {
+			_, left, err = evm.CallWithContext(c.Context(), vm.AccountRef(Sender), to, c.Input, c.Gas, value)
+		} else {
+			_, left, err = evm.Call(vm.AccountRef(Sender), to, c.Input, c.Gas, value)
+		}
+	}
+	res := &Result{Err: tracer.err, Depth: tracer.errDepth, Soft: tracer.soft, GasLeft: left}
+	if res.Err == nil && err != nil {
+		// The transaction failed before entering its frame.
+		res.Err, res.Depth = err, 0
+	}
+	return res, nil
+}
+
+// Check returns an error if res is not the outcome expected by the case.
+func (c *Case) Check(res *Result) error {
+	if !matches(res.Err, c.Expect) {
+		return fmt.Errorf("%s: error mismatch: have %v, want %v", c.Name, res.Err, c.Expect)
+	}
+	if res.Depth != c.Depth {
+		return fmt.Errorf("%s: depth mismatch: have %d, want %d", c.Name, res.Depth, c.Depth)
+	}
+	if res.Soft != c.Soft {
+		return fmt.Errorf("%s: soft failure mismatch: have %t, want %t", c.Name, res.Soft, c.Soft)
+	}
+	if c.AllGas && res.GasLeft != 0 {
+		return fmt.Errorf("%s: gas left: have %d, want 0", c.Name, res.GasLeft)
+	}
+	if !c.AllGas && res.GasLeft == 0 {
+		return fmt.Errorf("%s: all gas consumed", c.Name)
+	}
+	return nil
+}
+
+// Verify runs the case and checks its outcome.
+func (c *Case) Verify() error {
+	res, err := c.Run()
+	if err != nil {
+		return err
+	}
+	return c.Check(res)
+}
+
+// Verify runs and checks every case of the corpus, returning the first failure.
+func Verify() error {
+	for _, c := range Corpus() {
+		if err := c.Verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether have is the error want stands for.
+func matches(have, want error) bool {
+	if have == nil || want == nil {
+		return have == want
+	}
+	switch want := want.(type) {
+	case *vm.ErrOutOfGasKind:
+		var err *vm.ErrOutOfGasKind
+		return errors.As(have, &err) && err.Cause == want.Cause
+	case *vm.ErrStackUnderflow:
+		var err *vm.ErrStackUnderflow
+		return errors.As(have, &err)
+	case *vm.ErrStackOverflow:
+		var err *vm.ErrStackOverflow
+		return errors.As(have, &err)
+	case *vm.ErrInvalidOpCode:
+		var err *vm.ErrInvalidOpCode
+		return errors.As(have, &err)
+	case *vm.ErrUnsupportedOpCode:
+		var err *vm.ErrUnsupportedOpCode
+		return errors.As(have, &err)
+	}
+	return errors.Is(have, want)
+}
+
+// softErrors maps the execution states of the calls and creations failing
+// without entering their frame to the error they stand for.
+var softErrors = map[vm.ExecutionState]error{
+	vm.ExecErrorDepth:                    vm.ErrDepth,
+	vm.ExecErrorInsufficientBalance:      vm.ErrInsufficientBalance,
+	vm.ExecErrorContractAddressCollision: vm.ErrContractAddressCollision,
+	vm.ExecErrorNonceUintOverflow:        vm.ErrNonceUintOverflow,
+}
+
+// errorTracer is an EVMLogger recording the first error of a transaction and
+// the depth of the frame it occurred in.
+//
+// Errors only failing a CALL or CREATE never reach the frame executing it, the
+// tracer is told about them by the execution state of the step.
+type errorTracer struct {
+	err      error
+	errDepth int
+	soft     bool
+
+	depth int // depth of the frame being executed
+}
+
+func (t *errorTracer) fail(err error, depth int, soft bool) {
+	if t.err == nil {
+		t.err, t.errDepth, t.soft = err, depth, soft
+	}
+}
+
+func (t *errorTracer) CaptureTxStart(gasLimit uint64) {}
+
+func (t *errorTracer) CaptureTxEnd(restGas uint64) {}
+
+func (t *errorTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.depth = 1
+}
+
+func (t *errorTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	if err != nil {
+		t.fail(err, 1, false)
+	}
+}
+
+func (t *errorTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.depth++
+}
+
+func (t *errorTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if err != nil {
+		t.fail(err, t.depth, false)
+	}
+	t.depth--
+}
+
+func (t *errorTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if err != nil {
+		t.fail(err, depth, false)
+	}
+}
+
+func (t *errorTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	t.fail(err, depth, false)
+}
+
+// CaptureExecutionState implements the vm.ExecutionStateLogger interface,
+// recording the calls and creations failing before entering their frame.
+func (t *errorTracer) CaptureExecutionState(pc uint64, op vm.OpCode, state vm.ExecutionState, depth int) {
+	if err, ok := softErrors[state]; ok {
+		t.fail(err, depth, true)
+	}
+}
@@ -19,25 +19,30 @@ package vm
 import (
 	"errors"
 	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 // List evm execution errors
 var (
 	ErrOutOfGas = errors.New("out of gas")
 	// * 用来触发OOG Error
-	ErrCodeStoreOutOfGas = errors.New("contract creation code storage out of gas")
-	ErrDepth             = errors.New("max call depth exceeded")
+	ErrCodeStoreOutOfGas       = errors.New("contract creation code storage out of gas")
+	ErrCircuitCapacityExceeded = errors.New("circuit capacity exceeded")
+	ErrDepth                   = errors.New("max call depth exceeded")
 	// * 与外层error.go中定义的ErrInsufficientFund不同
 	ErrInsufficientBalance      = errors.New("insufficient balance for transfer")
 	ErrContractAddressCollision = errors.New("contract address collision")
 	ErrExecutionReverted        = errors.New("execution reverted")
 	ErrMaxCodeSizeExceeded      = errors.New("max code size exceeded")
+	ErrMaxInitCodeSizeExceeded  = errors.New("max initcode size exceeded")
 	ErrInvalidJump              = errors.New("invalid jump destination")
 	ErrWriteProtection          = errors.New("write protection")
 	ErrReturnDataOutOfBounds    = errors.New("return data out of bounds")
 	ErrGasUintOverflow          = errors.New("gas uint64 overflow")
 	ErrInvalidCode              = errors.New("invalid code: must not begin with 0xef")
 	ErrNonceUintOverflow        = errors.New("nonce uint64 overflow")
+	ErrExecutionAborted         = errors.New("execution aborted")
 
 	// errStopToken is an internal token indicating interpreter loop termination,
 	// never returned to outside callers.
@@ -82,3 +87,171 @@ type ErrInvalidOpCode struct {
 }
 
 func (e *ErrInvalidOpCode) Error() string { return fmt.Sprintf("invalid opcode: %s", e.opcode) }
+
+// ErrUnsupportedOpCode wraps an evm error when an opcode the zkEVM circuit can't
+// prove is about to execute. Address is set when the opcode is a call to an
+// unsupported precompile.
+type ErrUnsupportedOpCode struct {
+	Op      OpCode
+	PC      uint64
+	Depth   int
+	Address *common.Address
+}
+
+func (e *ErrUnsupportedOpCode) Error() string {
+	if e.Address != nil {
+		return fmt.Sprintf("unsupported precompile: %s to %x at pc %d, depth %d", e.Op, *e.Address, e.PC, e.Depth)
+	}
+	return fmt.Sprintf("unsupported opcode: %s at pc %d, depth %d", e.Op, e.PC, e.Depth)
+}
+
+// ErrExecutionCancelled reports an execution stopped because its context was
+// done, with the gas used until then. It matches ErrExecutionAborted and the
+// cause, like context.DeadlineExceeded, with errors.Is.
+type ErrExecutionCancelled struct {
+	Cause   error
+	GasUsed uint64
+}
+
+func (e *ErrExecutionCancelled) Error() string {
+	return fmt.Sprintf("%v: %v (gas used %d)", ErrExecutionAborted, e.Cause, e.GasUsed)
+}
+
+// Unwrap returns the cause of the cancellation.
+func (e *ErrExecutionCancelled) Unwrap() error { return e.Cause }
+
+// Is makes the error match ErrExecutionAborted.
+func (e *ErrExecutionCancelled) Is(target error) bool { return target == ErrExecutionAborted }
+
+// OutOfGasCause is the part of an operation's gas cost that couldn't be paid.
+// Every cause is proven by a separate gadget of the circuit.
+type OutOfGasCause uint8
+
+const (
+	OutOfGasConstant        OutOfGasCause = iota + 1 // constant gas of the opcode
+	OutOfGasMemoryExpansion                          // memory expansion of MLOAD, MSTORE, RETURN, ...
+	OutOfGasCall                                     // dynamic gas of the CALL family
+	OutOfGasSloadSstore                              // dynamic gas of SLOAD and SSTORE
+	OutOfGasExp                                      // exponent byte gas of EXP
+	OutOfGasSha3                                     // word gas of KECCAK256
+	OutOfGasCopy                                     // word gas of the *COPY opcodes
+	OutOfGasLog                                      // topic and data gas of LOG0-LOG4
+	OutOfGasAccountAccess                            // cold account access of BALANCE, EXTCODESIZE, EXTCODEHASH
+	OutOfGasCreate                                   // dynamic gas of CREATE and CREATE2
+	OutOfGasSelfDestruct                             // dynamic gas of SELFDESTRUCT
+)
+
+var outOfGasCauseToString = map[OutOfGasCause]string{
+	OutOfGasConstant:        "constant",
+	OutOfGasMemoryExpansion: "memory expansion",
+	OutOfGasCall:            "call",
+	OutOfGasSloadSstore:     "sload/sstore",
+	OutOfGasExp:             "exp",
+	OutOfGasSha3:            "sha3",
+	OutOfGasCopy:            "copy",
+	OutOfGasLog:             "log",
+	OutOfGasAccountAccess:   "account access",
+	OutOfGasCreate:          "create",
+	OutOfGasSelfDestruct:    "selfdestruct",
+}
+
+func (c OutOfGasCause) String() string {
+	if s, ok := outOfGasCauseToString[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("cause %d", uint8(c))
+}
+
+// outOfGasCause returns the cause of an opcode running out of gas while paying
+// for its dynamic gas cost.
+func outOfGasCause(op OpCode) OutOfGasCause {
+	if op >= LOG0 && op <= LOG4 {
+		return OutOfGasLog
+	}
+	switch op {
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		return OutOfGasCall
+	case SLOAD, SSTORE:
+		return OutOfGasSloadSstore
+	case EXP:
+		return OutOfGasExp
+	case KECCAK256:
+		return OutOfGasSha3
+	case CALLDATACOPY, CODECOPY, RETURNDATACOPY, EXTCODECOPY:
+		return OutOfGasCopy
+	case BALANCE, EXTCODESIZE, EXTCODEHASH:
+		return OutOfGasAccountAccess
+	case CREATE, CREATE2:
+		return OutOfGasCreate
+	case SELFDESTRUCT:
+		return OutOfGasSelfDestruct
+	}
+	return OutOfGasMemoryExpansion
+}
+
+// ErrOutOfGasKind wraps ErrOutOfGas with the cause of the failure and the gas
+// figures of the failing step. Required is zero if the cost couldn't even be
+// computed without overflowing.
+//
+// The error message is the one of ErrOutOfGas, which tracers and RPC clients
+// rely on; use Detail for the extended description.
+type ErrOutOfGasKind struct {
+	Cause     OutOfGasCause
+	Required  uint64
+	Available uint64
+}
+
+func (e *ErrOutOfGasKind) Error() string { return ErrOutOfGas.Error() }
+
+// Unwrap makes the error match ErrOutOfGas with errors.Is.
+func (e *ErrOutOfGasKind) Unwrap() error { return ErrOutOfGas }
+
+// Detail returns the message of the error including cause and gas figures.
+func (e *ErrOutOfGasKind) Detail() string {
+	return fmt.Sprintf("out of gas: %s (required %d, available %d)", e.Cause, e.Required, e.Available)
+}
+
+// VMError wraps an error of the interpreter loop with the location it occurred
+// at, to find the step a failed proof is about. It matches the wrapped error
+// with errors.Is and errors.As.
+//
+// The error message is the one of the wrapped error, which tracers and RPC
+// clients rely on; use Detail for the extended description.
+type VMError struct {
+	Err      error
+	PC       uint64
+	Op       OpCode
+	Depth    int
+	CodeAddr common.Address // Address of the executing code, the callee's for DELEGATECALL and CALLCODE
+	CodeHash common.Hash
+}
+
+func (e *VMError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the wrapped error.
+func (e *VMError) Unwrap() error { return e.Err }
+
+// Detail returns the message of the error including its location.
+func (e *VMError) Detail() string {
+	msg := e.Err.Error()
+	if oog, ok := e.Err.(*ErrOutOfGasKind); ok {
+		msg = oog.Detail()
+	}
+	return fmt.Sprintf("%s at pc %d (%s), depth %d, code %x (hash %x)", msg, e.PC, e.Op, e.Depth, e.CodeAddr, e.CodeHash)
+}
+
+// newVMError wraps err with the location of the step of contract it occurred at.
+func newVMError(err error, pc uint64, op OpCode, depth int, contract *Contract) *VMError {
+	codeAddr := contract.Address()
+	if contract.CodeAddr != nil {
+		codeAddr = *contract.CodeAddr
+	}
+	return &VMError{
+		Err:      err,
+		PC:       pc,
+		Op:       op,
+		Depth:    depth,
+		CodeAddr: codeAddr,
+		CodeHash: contract.CodeHash,
+	}
+}
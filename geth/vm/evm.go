@@ -53,6 +53,10 @@ type (
 func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
 	var precompiles map[common.Address]PrecompiledContract
 	switch {
+	case evm.chainRules.IsPrague:
+		precompiles = PrecompiledContractsPrague
+	case evm.chainRules.IsCancun:
+		precompiles = PrecompiledContractsCancun
 	case evm.chainRules.IsBerlin:
 		precompiles = PrecompiledContractsBerlin
 	case evm.chainRules.IsIstanbul:
@@ -92,6 +96,8 @@ type BlockContext struct {
 	Difficulty  *big.Int       // Provides information for DIFFICULTY
 	BaseFee     *big.Int       // Provides information for BASEFEE
 	Random      *common.Hash   // Provides information for RANDOM
+
+	ExcessBlobGas *uint64 // Prices the blob gas and BLOBBASEFEE, nil before cancun
 }
 
 // * TxContext给EVM提供一些tx相关的信息 -> 根据tx的变化，这些信息貌似也可以改变
@@ -102,7 +108,13 @@ type TxContext struct {
 	Origin common.Address // Provides information for ORIGIN
 	// * 所以gasPrice是每笔transaction的？
 	// * gasLimit是一个block可用的？
-	GasPrice *big.Int // Provides information for GASPRICE
+	GasPrice   *big.Int      // Provides information for GASPRICE
+	BlobHashes []common.Hash // Provides information for BLOBHASH
+
+	// TxID is the index of the transaction within the block, starting at 1.
+	// It identifies the transaction in the call contexts, rw operations and
+	// copy events recorded while running it.
+	TxID uint64
 }
 
 // EVM is the Ethereum Virtual Machine base object and provides
@@ -158,12 +170,58 @@ type EVM struct {
 	// * abort用来终止call operation
 	// ![issue] 而且被设置为atomically -> 不清楚什么意思
 	abort int32
+	// abortSeen is set once the interpreter stopped an execution on the abort
+	// flag.
+	abortSeen bool
 	// callGasTemp holds the gas available for the current call. This is needed because the
 	// available gas is calculated in gasCall* according to the 63/64 rule and later
 	// applied in opCall*.
 	// * callGasTemp保存当前call的gas available（可用的gas，或者一个call消耗的gas）
 	// ![issue] 63/64的byte规则，以及opCall*这个东西
 	callGasTemp uint64
+	// callArgsTemp holds the call data and return data layout of the current
+	// call, or the location of the init code of the current creation. Like
+	// callGasTemp, it is set by the interpreter before a CALL or CREATE family
+	// opcode runs and consumed when the callee frame is entered.
+	callArgsTemp callArgs
+
+	// callContexts and callStack track the call contexts of the frames
+	// opened by the current transaction while tracing.
+	callContexts []*CallContext
+	callStack    []*CallContext
+
+	// bytecodes holds every code executed by the EVM, if bytecode recording
+	// is enabled.
+	bytecodes    []*Bytecode
+	bytecodeSeen map[common.Hash]struct{}
+
+	// copyEvents holds the bulk copies made by the EVM, if copy event
+	// recording is enabled.
+	copyEvents []*CopyEvent
+
+	// keccakInputs holds the inputs of the keccak hashes computed in the
+	// current transaction, if keccak input recording is enabled.
+	keccakInputs [][]byte
+
+	// provingCost is the proving cost of everything executed so far, if a
+	// proving cost schedule is configured.
+	provingCost uint64
+
+	// unsupportedErr is the first unsupported feature hit since the last
+	// Reset, if Config.Unsupported is set.
+	unsupportedErr *ErrUnsupportedOpCode
+
+	// createdContracts holds the contracts created by the current transaction,
+	// the only ones SELFDESTRUCT deletes as of EIP-6780.
+	createdContracts map[common.Address]struct{}
+
+	// transient holds the transient storage of EIP-1153, journaled along with
+	// the StateDB snapshots.
+	transient transientJournal
+
+	// pendingState is the step whose execution state is held back until its
+	// outcome is known, if the tracer wants execution states.
+	pendingState *pendingExecutionState
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
@@ -176,7 +234,7 @@ func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig
 		StateDB:     statedb,
 		Config:      config,
 		chainConfig: chainConfig,
-		chainRules:  chainConfig.Rules(blockCtx.BlockNumber, blockCtx.Random != nil),
+		chainRules:  chainConfig.Rules(blockCtx.BlockNumber, blockCtx.Random != nil, blockCtx.Time.Uint64()),
 	}
 	// * 而且也创建一个新的EVM interpreter -> 根据每个block吗，还是根据每个transaction（甚至每个call）
 	// * 应该是给外部（执行contract代码的地方调用的）
@@ -190,6 +248,10 @@ func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig
 func (evm *EVM) Reset(txCtx TxContext, statedb StateDB) {
 	evm.TxContext = txCtx
 	evm.StateDB = statedb
+	evm.keccakInputs = nil
+	evm.unsupportedErr = nil
+	evm.createdContracts = nil
+	evm.transient.reset()
 }
 
 // Cancel cancels any running EVM operation. This may be called concurrently and
@@ -226,6 +288,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	// * 需要搞懂depth是什么（应该是stack的深度）
 	// * 如果我们这笔交易需要的depth(evm.depth)超过了params.CallCreateDepth（call/create最大的depth -> 1024）就会报错
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.flushExecutionState(ErrDepth)
 		return nil, gas, ErrDepth
 	}
 	// Fail if we're trying to transfer more than the available balance
@@ -233,10 +296,12 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	// * 我感觉这里应该是caller的balance -> 所以我们的出发点就错了，应该是调用合约账户的余额不足，而不是合约账户的余额不足
 	if value.Sign() != 0 && !evm.Context.CanTransfer(evm.StateDB, caller.Address(), value) {
 		// ! Error的位置
+		evm.flushExecutionState(ErrInsufficientBalance)
 		return nil, gas, ErrInsufficientBalance
 	}
+	evm.flushExecutionState(nil)
 	// * 如果通过了上面两个error check，就可以更新snapshot（也就是state）
-	snapshot := evm.StateDB.Snapshot()
+	snapshot := evm.snapshot()
 	// * 还不太清楚precompile主要做什么，传入contract address，返回不同的版本的compile？
 	// ![issue] precompile是什么？
 	p, isPrecompile := evm.precompile(addr)
@@ -250,9 +315,17 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 					// * 使用debug tracer -> 不太清楚要做什么
 					// ![issue] tracer是什么？
 					evm.Config.Tracer.CaptureStart(evm, caller.Address(), addr, false, input, gas, value)
-					evm.Config.Tracer.CaptureEnd(ret, 0, 0, nil)
 				} else {
 					evm.Config.Tracer.CaptureEnter(CALL, caller.Address(), addr, input, gas, value)
+				}
+			}
+			if evm.tracksCallContexts() {
+				evm.exitCallContext(evm.enterCallContext(CALL, caller.Address(), addr, input, value, emptyCodeHash), nil, nil)
+			}
+			if evm.Config.Debug {
+				if evm.depth == 0 {
+					evm.Config.Tracer.CaptureEnd(ret, 0, 0, nil)
+				} else {
 					evm.Config.Tracer.CaptureExit(ret, 0, nil)
 				}
 			}
@@ -280,14 +353,29 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 			}(gas)
 		}
 	}
+	if evm.tracksCallContexts() {
+		ctx := evm.enterCallContext(CALL, caller.Address(), addr, input, value, evm.resolveCodeHash(addr))
+		defer func() { evm.exitCallContext(ctx, ret, err) }()
+	}
 
-	if isPrecompile {
+	if isPrecompile && evm.depth == 0 && evm.Config.Unsupported != nil {
+		// Transactions calling a precompile directly run no opcode for the
+		// interpreter to check
+		err = evm.Config.Unsupported.checkPrecompile(evm, addr)
+	}
+	if err != nil {
+		// The transaction fails without running the precompile
+	} else if isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		evm.addPrecompileProvingCost(addr, input)
+		if err == nil {
+			err = evm.addPrecompileRows(input, ret)
+		}
 	} else {
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		// * 获取合约地址对应的code
-		code := evm.StateDB.GetCode(addr)
+		code := evm.resolveCode(addr)
 		if len(code) == 0 {
 			ret, err = nil, nil // gas is unchanged
 		} else {
@@ -296,7 +384,8 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 			// The depth-check is already done, and precompiles handled above
 			contract := NewContract(caller, AccountRef(addrCopy), value, gas)
 			// * 调用这个合约
-			contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), code)
+			contract.SetCallCode(&addrCopy, evm.resolveCodeHash(addrCopy), code)
+			evm.recordBytecode(contract.CodeHash, contract.Code)
 			ret, err = evm.interpreter.Run(contract, input, false)
 			gas = contract.Gas
 		}
@@ -313,8 +402,10 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 
 	if err != nil {
 		// * 如果有error，就把snapshot的状态给revert
-		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != ErrExecutionReverted {
+		evm.revertToSnapshot(snapshot)
+		// Aborted executions keep their gas, to report the gas used until
+		// the cancellation.
+		if err != ErrExecutionReverted && err != ErrExecutionAborted {
 			gas = 0
 		}
 		// TODO: consider clearing up unused snapshots:
@@ -334,6 +425,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.flushExecutionState(ErrDepth)
 		return nil, gas, ErrDepth
 	}
 	// Fail if we're trying to transfer more than the available balance
@@ -341,9 +433,11 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	// if caller doesn't have enough balance, it would be an error to allow
 	// over-charging itself. So the check here is necessary.
 	if !evm.Context.CanTransfer(evm.StateDB, caller.Address(), value) {
+		evm.flushExecutionState(ErrInsufficientBalance)
 		return nil, gas, ErrInsufficientBalance
 	}
-	var snapshot = evm.StateDB.Snapshot()
+	evm.flushExecutionState(nil)
+	var snapshot = evm.snapshot()
 
 	// Invoke tracer hooks that signal entering/exiting a call frame
 	if evm.Config.Debug {
@@ -352,22 +446,31 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 			evm.Config.Tracer.CaptureExit(ret, startGas-gas, err)
 		}(gas)
 	}
+	if evm.tracksCallContexts() {
+		ctx := evm.enterCallContext(CALLCODE, caller.Address(), addr, input, value, evm.resolveCodeHash(addr))
+		defer func() { evm.exitCallContext(ctx, ret, err) }()
+	}
 
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		evm.addPrecompileProvingCost(addr, input)
+		if err == nil {
+			err = evm.addPrecompileRows(input, ret)
+		}
 	} else {
 		addrCopy := addr
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		contract := NewContract(caller, AccountRef(caller.Address()), value, gas)
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		contract.SetCallCode(&addrCopy, evm.resolveCodeHash(addrCopy), evm.resolveCode(addrCopy))
+		evm.recordBytecode(contract.CodeHash, contract.Code)
 		ret, err = evm.interpreter.Run(contract, input, false)
 		gas = contract.Gas
 	}
 	if err != nil {
-		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != ErrExecutionReverted {
+		evm.revertToSnapshot(snapshot)
+		if err != ErrExecutionReverted && err != ErrExecutionAborted {
 			gas = 0
 		}
 	}
@@ -382,9 +485,11 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []byte, gas uint64) (ret []byte, leftOverGas uint64, err error) {
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.flushExecutionState(ErrDepth)
 		return nil, gas, ErrDepth
 	}
-	var snapshot = evm.StateDB.Snapshot()
+	evm.flushExecutionState(nil)
+	var snapshot = evm.snapshot()
 
 	// Invoke tracer hooks that signal entering/exiting a call frame
 	if evm.Config.Debug {
@@ -393,21 +498,30 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 			evm.Config.Tracer.CaptureExit(ret, startGas-gas, err)
 		}(gas)
 	}
+	if evm.tracksCallContexts() {
+		ctx := evm.enterCallContext(DELEGATECALL, caller.Address(), addr, input, nil, evm.resolveCodeHash(addr))
+		defer func() { evm.exitCallContext(ctx, ret, err) }()
+	}
 
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		evm.addPrecompileProvingCost(addr, input)
+		if err == nil {
+			err = evm.addPrecompileRows(input, ret)
+		}
 	} else {
 		addrCopy := addr
 		// Initialise a new contract and make initialise the delegate values
 		contract := NewContract(caller, AccountRef(caller.Address()), nil, gas).AsDelegate()
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		contract.SetCallCode(&addrCopy, evm.resolveCodeHash(addrCopy), evm.resolveCode(addrCopy))
+		evm.recordBytecode(contract.CodeHash, contract.Code)
 		ret, err = evm.interpreter.Run(contract, input, false)
 		gas = contract.Gas
 	}
 	if err != nil {
-		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != ErrExecutionReverted {
+		evm.revertToSnapshot(snapshot)
+		if err != ErrExecutionReverted && err != ErrExecutionAborted {
 			gas = 0
 		}
 	}
@@ -421,14 +535,16 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte, gas uint64) (ret []byte, leftOverGas uint64, err error) {
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.flushExecutionState(ErrDepth)
 		return nil, gas, ErrDepth
 	}
+	evm.flushExecutionState(nil)
 	// We take a snapshot here. This is a bit counter-intuitive, and could probably be skipped.
 	// However, even a staticcall is considered a 'touch'. On mainnet, static calls were introduced
 	// after all empty accounts were deleted, so this is not required. However, if we omit this,
 	// then certain tests start failing; stRevertTest/RevertPrecompiledTouchExactOOG.json.
 	// We could change this, but for now it's left for legacy reasons
-	var snapshot = evm.StateDB.Snapshot()
+	var snapshot = evm.snapshot()
 
 	// We do an AddBalance of zero here, just in order to trigger a touch.
 	// This doesn't matter on Mainnet, where all empties are gone at the time of Byzantium,
@@ -443,9 +559,17 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 			evm.Config.Tracer.CaptureExit(ret, startGas-gas, err)
 		}(gas)
 	}
+	if evm.tracksCallContexts() {
+		ctx := evm.enterCallContext(STATICCALL, caller.Address(), addr, input, nil, evm.resolveCodeHash(addr))
+		defer func() { evm.exitCallContext(ctx, ret, err) }()
+	}
 
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		evm.addPrecompileProvingCost(addr, input)
+		if err == nil {
+			err = evm.addPrecompileRows(input, ret)
+		}
 	} else {
 		// At this point, we use a copy of address. If we don't, the go compiler will
 		// leak the 'contract' to the outer scope, and make allocation for 'contract'
@@ -454,7 +578,8 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		contract := NewContract(caller, AccountRef(addrCopy), new(big.Int), gas)
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		contract.SetCallCode(&addrCopy, evm.resolveCodeHash(addrCopy), evm.resolveCode(addrCopy))
+		evm.recordBytecode(contract.CodeHash, contract.Code)
 		// When an error was returned by the EVM or when setting the creation code
 		// above we revert to the snapshot and consume any gas remaining. Additionally
 		// when we're in Homestead this also counts for code storage gas errors.
@@ -462,14 +587,53 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 		gas = contract.Gas
 	}
 	if err != nil {
-		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != ErrExecutionReverted {
+		evm.revertToSnapshot(snapshot)
+		if err != ErrExecutionReverted && err != ErrExecutionAborted {
 			gas = 0
 		}
 	}
 	return ret, gas, err
 }
 
+// Snapshot takes a snapshot of the StateDB and of the transient storage, to be
+// reverted with RevertToSnapshot. Reverting the StateDB directly leaves the
+// transient storage as is.
+func (evm *EVM) Snapshot() int {
+	id := evm.StateDB.Snapshot()
+	evm.transient.snapshot(id)
+	return id
+}
+
+// RevertToSnapshot reverts the StateDB and the transient storage to a snapshot
+// taken with Snapshot.
+func (evm *EVM) RevertToSnapshot(id int) {
+	evm.StateDB.RevertToSnapshot(id)
+	evm.transient.revert(id)
+}
+
+// snapshot takes a StateDB snapshot, letting the tracer journal the writes made
+// from now on.
+func (evm *EVM) snapshot() int {
+	id := evm.Snapshot()
+	if evm.Config.Debug {
+		if logger, ok := evm.Config.Tracer.(SnapshotLogger); ok {
+			logger.CaptureSnapshot(id)
+		}
+	}
+	return id
+}
+
+// revertToSnapshot reverts the StateDB to a snapshot, letting the tracer record
+// which writes were undone.
+func (evm *EVM) revertToSnapshot(id int) {
+	evm.RevertToSnapshot(id)
+	if evm.Config.Debug {
+		if logger, ok := evm.Config.Tracer.(SnapshotLogger); ok {
+			logger.CaptureRevert(id)
+		}
+	}
+}
+
 type codeAndHash struct {
 	code []byte
 	hash common.Hash
@@ -502,13 +666,22 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	// * 		不过这里要通过balance的检查，说明caller余额是够的
 	// * 3）nonce是正确的
 	if evm.depth > int(params.CallCreateDepth) {
+		evm.flushExecutionState(ErrDepth)
 		return nil, common.Address{}, gas, ErrDepth
 	}
 	if !evm.Context.CanTransfer(evm.StateDB, caller.Address(), value) {
+		evm.flushExecutionState(ErrInsufficientBalance)
 		return nil, common.Address{}, gas, ErrInsufficientBalance
 	}
+	// The opcodes and transactions are rejected earlier, this only guards
+	// direct callers.
+	if (evm.chainRules.IsShanghai || evm.interpreter.eipEnabled(3860)) && len(codeAndHash.code) > params.MaxInitCodeSize {
+		evm.flushExecutionState(ErrMaxInitCodeSizeExceeded)
+		return nil, common.Address{}, gas, ErrMaxInitCodeSizeExceeded
+	}
 	nonce := evm.StateDB.GetNonce(caller.Address())
 	if nonce+1 < nonce {
+		evm.flushExecutionState(ErrNonceUintOverflow)
 		return nil, common.Address{}, gas, ErrNonceUintOverflow
 	}
 	evm.StateDB.SetNonce(caller.Address(), nonce+1)
@@ -516,20 +689,32 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	// the access-list change should not be rolled back
 	// * 把这个地址添加到access-list（在snapshot之前）
 	// * 而且就算creation失败了，这个access-list也不会回滚
+	var warm bool
 	if evm.chainRules.IsBerlin {
+		warm = evm.StateDB.AddressInAccessList(address)
 		evm.StateDB.AddAddressToAccessList(address)
 	}
+	if evm.Config.Debug {
+		if logger, ok := evm.Config.Tracer.(CreateLogger); ok {
+			logger.CaptureCreate(evm, caller.Address(), nonce, address, warm)
+		}
+	}
 	// Ensure there's no existing contract already at the designated address
 	// * 检查这个合约地址之前是否部署过代码，通过之前的那个emptyCodeHash工具
 	// * 如果这个地址已经有代码，弹出ErrContractAddressCollision
 	contractHash := evm.StateDB.GetCodeHash(address)
 	if evm.StateDB.GetNonce(address) != 0 || (contractHash != (common.Hash{}) && contractHash != emptyCodeHash) {
+		evm.flushExecutionState(ErrContractAddressCollision)
 		return nil, common.Address{}, 0, ErrContractAddressCollision
 	}
+	evm.flushExecutionState(nil)
 	// * 如果通过了emptyCodeHash检查，就可以创建一个新的合约账户了
 	// Create a new account on the state
-	snapshot := evm.StateDB.Snapshot()
+	snapshot := evm.snapshot()
 	evm.StateDB.CreateAccount(address) // * 创建新合约账户 -> 也说明报错在创建了新合约之后
+	if evm.chainRules.IsCancun {
+		evm.markCreated(address)
+	}
 	if evm.chainRules.IsEIP158 {
 		evm.StateDB.SetNonce(address, 1)
 	}
@@ -541,8 +726,12 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	// ![issue] 这里说contract只是一个execution context，是什么意思？是因为sandbox model吗
 	contract := NewContract(caller, AccountRef(address), value, gas)
 	contract.SetCodeOptionalHash(&address, codeAndHash)
+	if evm.Config.EnableBytecodeRecording {
+		evm.recordBytecode(codeAndHash.Hash(), codeAndHash.code)
+	}
 
 	// * 正常操作，开启tracer和debug
+	var callCtx *CallContext
 	if evm.Config.Debug {
 		if evm.depth == 0 {
 			evm.Config.Tracer.CaptureStart(evm, caller.Address(), address, true, codeAndHash.code, gas, value)
@@ -550,6 +739,25 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 			evm.Config.Tracer.CaptureEnter(typ, caller.Address(), address, codeAndHash.code, gas, value)
 		}
 	}
+	initCodeOffset := evm.callArgsTemp.inOffset
+	if evm.tracksCallContexts() {
+		callCtx = evm.enterCallContext(typ, caller.Address(), address, codeAndHash.code, value, codeAndHash.Hash())
+	}
+	// The init code is copied as the frame is entered, from the calldata of a
+	// creation transaction or from the memory of the creator.
+	if evm.Config.EnableCopyEventRecording {
+		event := &CopyEvent{
+			SrcType: CopyDataTxCalldata,
+			SrcID:   uint64ToHash(callCtx.TxID),
+			DstType: CopyDataBytecode,
+			DstID:   codeAndHash.Hash(),
+			Bytes:   common.CopyBytes(codeAndHash.code),
+		}
+		if !callCtx.IsRoot {
+			event.SrcType, event.SrcID, event.SrcOffset = CopyDataMemory, uint64ToHash(callCtx.CallerID), initCodeOffset
+		}
+		evm.recordCopyEvent(event)
+	}
 
 	start := time.Now()
 
@@ -589,6 +797,7 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 		if contract.UseGas(createDataGas) {
 			// * 如果gas足够则部署
 			evm.StateDB.SetCode(address, ret)
+			evm.recordKeccakInput(ret)
 		} else {
 			// ! Error的位置
 			// * gas不够就弹出我们需要的OOG error
@@ -596,6 +805,9 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 		}
 	}
 
+	// The RETURN ending the init code fails if the code can't be stored.
+	evm.flushExecutionState(err)
+
 	// When an error was returned by the EVM or when setting the creation code
 	// above we revert to the snapshot and consume any gas remaining. Additionally
 	// when we're in homestead this also counts for code storage gas errors.
@@ -603,14 +815,17 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	// * 如果产生了error就revert snapshot，并且消费任意的一笔gas
 	// * 跟Call函数的最后一步差不多
 	if err != nil && (evm.chainRules.IsHomestead || err != ErrCodeStoreOutOfGas) {
-		evm.StateDB.RevertToSnapshot(snapshot)
+		evm.revertToSnapshot(snapshot)
 		// * OK 所以每次发生ErrExecutionReverted的时候交易都不上链
 		// * 如果不是这个error，就会消费gas，并且失败的交易被提交到链上
-		if err != ErrExecutionReverted {
+		if err != ErrExecutionReverted && err != ErrExecutionAborted {
 			contract.UseGas(contract.Gas)
 		}
 	}
 
+	if callCtx != nil {
+		evm.exitCallContext(callCtx, ret, err)
+	}
 	if evm.Config.Debug {
 		if evm.depth == 0 {
 			evm.Config.Tracer.CaptureEnd(ret, gas-contract.Gas, time.Since(start), err)
@@ -623,7 +838,10 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 
 // Create creates a new contract using code as deployment code.
 func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
-	contractAddr = crypto.CreateAddress(caller.Address(), evm.StateDB.GetNonce(caller.Address()))
+	nonce := evm.StateDB.GetNonce(caller.Address())
+	contractAddr = crypto.CreateAddress(caller.Address(), nonce)
+	evm.recordCreateAddressInput(caller.Address(), nonce)
+	evm.recordKeccakInput(code)
 	return evm.create(caller, &codeAndHash{code: code}, gas, value, contractAddr, CREATE)
 }
 
@@ -634,6 +852,8 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 func (evm *EVM) Create2(caller ContractRef, code []byte, gas uint64, endowment *big.Int, salt *uint256.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
 	codeAndHash := &codeAndHash{code: code}
 	contractAddr = crypto.CreateAddress2(caller.Address(), salt.Bytes32(), codeAndHash.Hash().Bytes())
+	evm.recordKeccakInput(code)
+	evm.recordCreate2AddressInput(caller.Address(), salt, codeAndHash.Hash())
 	return evm.create(caller, codeAndHash, gas, endowment, contractAddr, CREATE2)
 }
 
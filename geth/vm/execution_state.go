@@ -0,0 +1,539 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExecutionState identifies the circuit gadget proving a step of the execution.
+// Opcodes sharing a gadget share an execution state, error exits have a state
+// per cause.
+type ExecutionState uint8
+
+const (
+	ExecBeginTx ExecutionState = iota + 1
+	ExecEndTx
+	ExecEndBlock
+	ExecCopyCodeToMemory
+	ExecCopyToMemory
+	ExecCopyToLog
+
+	ExecStop
+	ExecAddSub
+	ExecMulDivMod
+	ExecSdivSmod
+	ExecShl
+	ExecShr
+	ExecAddMod
+	ExecMulMod
+	ExecExp
+	ExecSignExtend
+	ExecCmp
+	ExecScmp
+	ExecIsZero
+	ExecBitwise
+	ExecNot
+	ExecByte
+	ExecSar
+	ExecSha3
+	ExecAddress
+	ExecBalance
+	ExecOrigin
+	ExecCaller
+	ExecCallValue
+	ExecCallDataLoad
+	ExecCallDataSize
+	ExecCallDataCopy
+	ExecCodeSize
+	ExecCodeCopy
+	ExecGasPrice
+	ExecExtCodeSize
+	ExecExtCodeCopy
+	ExecReturnDataSize
+	ExecReturnDataCopy
+	ExecExtCodeHash
+	ExecBlockHash
+	ExecBlockCtxU64
+	ExecBlockCtxU160
+	ExecBlockCtxU256
+	ExecChainID
+	ExecSelfBalance
+	ExecPop
+	ExecMemory
+	ExecSload
+	ExecSstore
+	ExecJump
+	ExecJumpi
+	ExecPC
+	ExecMsize
+	ExecGas
+	ExecJumpdest
+	ExecPush
+	ExecDup
+	ExecSwap
+	ExecLog
+	ExecCreate
+	ExecCall
+	ExecCallCode
+	ExecReturn
+	ExecDelegateCall
+	ExecCreate2
+	ExecStaticCall
+	ExecRevert
+	ExecSelfDestruct
+
+	ExecErrorInvalidOpcode
+	ExecErrorStackOverflow
+	ExecErrorStackUnderflow
+	ExecErrorWriteProtection
+	ExecErrorDepth
+	ExecErrorInsufficientBalance
+	ExecErrorContractAddressCollision
+	ExecErrorInvalidCreationCode
+	ExecErrorMaxCodeSizeExceeded
+	ExecErrorInvalidJump
+	ExecErrorReturnDataOutOfBound
+	ExecErrorOutOfGasConstant
+	ExecErrorOutOfGasStaticMemoryExpansion
+	ExecErrorOutOfGasDynamicMemoryExpansion
+	ExecErrorOutOfGasMemoryCopy
+	ExecErrorOutOfGasAccountAccess
+	ExecErrorOutOfGasCodeStore
+	ExecErrorOutOfGasLog
+	ExecErrorOutOfGasExp
+	ExecErrorOutOfGasSha3
+	ExecErrorOutOfGasExtCodeCopy
+	ExecErrorOutOfGasCall
+	ExecErrorOutOfGasSloadSstore
+	ExecErrorOutOfGasCreate2
+	ExecErrorOutOfGasSelfDestruct
+	ExecErrorNonceUintOverflow
+
+	// States added as of shanghai and cancun, appended to keep the values
+	// above stable.
+	ExecPush0
+	ExecMcopy
+	ExecTload
+	ExecTstore
+	ExecErrorMaxInitCodeSizeExceeded
+	ExecBlobHash
+	ExecBlobBaseFee
+)
+
+var executionStateToString = map[ExecutionState]string{
+	ExecBeginTx:          "BeginTx",
+	ExecEndTx:            "EndTx",
+	ExecEndBlock:         "EndBlock",
+	ExecCopyCodeToMemory: "CopyCodeToMemory",
+	ExecCopyToMemory:     "CopyToMemory",
+	ExecCopyToLog:        "CopyToLog",
+
+	ExecStop:           "STOP",
+	ExecAddSub:         "ADD_SUB",
+	ExecMulDivMod:      "MUL_DIV_MOD",
+	ExecSdivSmod:       "SDIV_SMOD",
+	ExecShl:            "SHL",
+	ExecShr:            "SHR",
+	ExecAddMod:         "ADDMOD",
+	ExecMulMod:         "MULMOD",
+	ExecExp:            "EXP",
+	ExecSignExtend:     "SIGNEXTEND",
+	ExecCmp:            "CMP",
+	ExecScmp:           "SCMP",
+	ExecIsZero:         "ISZERO",
+	ExecBitwise:        "BITWISE",
+	ExecNot:            "NOT",
+	ExecByte:           "BYTE",
+	ExecSar:            "SAR",
+	ExecSha3:           "SHA3",
+	ExecAddress:        "ADDRESS",
+	ExecBalance:        "BALANCE",
+	ExecOrigin:         "ORIGIN",
+	ExecCaller:         "CALLER",
+	ExecCallValue:      "CALLVALUE",
+	ExecCallDataLoad:   "CALLDATALOAD",
+	ExecCallDataSize:   "CALLDATASIZE",
+	ExecCallDataCopy:   "CALLDATACOPY",
+	ExecCodeSize:       "CODESIZE",
+	ExecCodeCopy:       "CODECOPY",
+	ExecGasPrice:       "GASPRICE",
+	ExecExtCodeSize:    "EXTCODESIZE",
+	ExecExtCodeCopy:    "EXTCODECOPY",
+	ExecReturnDataSize: "RETURNDATASIZE",
+	ExecReturnDataCopy: "RETURNDATACOPY",
+	ExecExtCodeHash:    "EXTCODEHASH",
+	ExecBlockHash:      "BLOCKHASH",
+	ExecBlockCtxU64:    "BLOCKCTXU64",
+	ExecBlockCtxU160:   "BLOCKCTXU160",
+	ExecBlockCtxU256:   "BLOCKCTXU256",
+	ExecChainID:        "CHAINID",
+	ExecSelfBalance:    "SELFBALANCE",
+	ExecPop:            "POP",
+	ExecMemory:         "MEMORY",
+	ExecSload:          "SLOAD",
+	ExecSstore:         "SSTORE",
+	ExecJump:           "JUMP",
+	ExecJumpi:          "JUMPI",
+	ExecPC:             "PC",
+	ExecMsize:          "MSIZE",
+	ExecGas:            "GAS",
+	ExecJumpdest:       "JUMPDEST",
+	ExecPush:           "PUSH",
+	ExecDup:            "DUP",
+	ExecSwap:           "SWAP",
+	ExecLog:            "LOG",
+	ExecCreate:         "CREATE",
+	ExecCall:           "CALL",
+	ExecCallCode:       "CALLCODE",
+	ExecReturn:         "RETURN",
+	ExecDelegateCall:   "DELEGATECALL",
+	ExecCreate2:        "CREATE2",
+	ExecStaticCall:     "STATICCALL",
+	ExecRevert:         "REVERT",
+	ExecSelfDestruct:   "SELFDESTRUCT",
+
+	ExecErrorInvalidOpcode:                  "ErrorInvalidOpcode",
+	ExecErrorStackOverflow:                  "ErrorStackOverflow",
+	ExecErrorStackUnderflow:                 "ErrorStackUnderflow",
+	ExecErrorWriteProtection:                "ErrorWriteProtection",
+	ExecErrorDepth:                          "ErrorDepth",
+	ExecErrorInsufficientBalance:            "ErrorInsufficientBalance",
+	ExecErrorContractAddressCollision:       "ErrorContractAddressCollision",
+	ExecErrorInvalidCreationCode:            "ErrorInvalidCreationCode",
+	ExecErrorMaxCodeSizeExceeded:            "ErrorMaxCodeSizeExceeded",
+	ExecErrorInvalidJump:                    "ErrorInvalidJump",
+	ExecErrorReturnDataOutOfBound:           "ErrorReturnDataOutOfBound",
+	ExecErrorOutOfGasConstant:               "ErrorOutOfGasConstant",
+	ExecErrorOutOfGasStaticMemoryExpansion:  "ErrorOutOfGasStaticMemoryExpansion",
+	ExecErrorOutOfGasDynamicMemoryExpansion: "ErrorOutOfGasDynamicMemoryExpansion",
+	ExecErrorOutOfGasMemoryCopy:             "ErrorOutOfGasMemoryCopy",
+	ExecErrorOutOfGasAccountAccess:          "ErrorOutOfGasAccountAccess",
+	ExecErrorOutOfGasCodeStore:              "ErrorOutOfGasCodeStore",
+	ExecErrorOutOfGasLog:                    "ErrorOutOfGasLOG",
+	ExecErrorOutOfGasExp:                    "ErrorOutOfGasEXP",
+	ExecErrorOutOfGasSha3:                   "ErrorOutOfGasSHA3",
+	ExecErrorOutOfGasExtCodeCopy:            "ErrorOutOfGasEXTCODECOPY",
+	ExecErrorOutOfGasCall:                   "ErrorOutOfGasCall",
+	ExecErrorOutOfGasSloadSstore:            "ErrorOutOfGasSloadSstore",
+	ExecErrorOutOfGasCreate2:                "ErrorOutOfGasCREATE2",
+	ExecErrorOutOfGasSelfDestruct:           "ErrorOutOfGasSELFDESTRUCT",
+	ExecErrorNonceUintOverflow:              "ErrorNonceUintOverflow",
+	ExecPush0:                               "PUSH0",
+	ExecMcopy:                               "MCOPY",
+	ExecTload:                               "TLOAD",
+	ExecTstore:                              "TSTORE",
+	ExecErrorMaxInitCodeSizeExceeded:        "ErrorMaxInitCodeSizeExceeded",
+	ExecBlobHash:                            "BLOBHASH",
+	ExecBlobBaseFee:                         "BLOBBASEFEE",
+}
+
+func (s ExecutionState) String() string {
+	if str, ok := executionStateToString[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("ExecutionState(%d)", uint8(s))
+}
+
+// IsError returns whether the state is an error exit.
+func (s ExecutionState) IsError() bool {
+	return s >= ExecErrorInvalidOpcode
+}
+
+// ExecutionStateLogger is an optional extension of EVMLogger, told the
+// execution state of every step once, when its outcome is known:
+//
+//   - steps failing before they execute, right before the deferred CaptureState;
+//   - steps of the CALL and CREATE families, after their CaptureState and right
+//     before the frame they open is entered, or when they fail to open it (depth,
+//     balance, collision, ...);
+//   - a RETURN ending init code, once the EVM decided whether the code can be
+//     stored;
+//   - every other step, after its CaptureState, once it executed.
+//
+// The BeginTx and EndTx states are reported with a zero pc and opcode when the
+// root frame is entered and exited.
+type ExecutionStateLogger interface {
+	CaptureExecutionState(pc uint64, op OpCode, state ExecutionState, depth int)
+}
+
+// pendingExecutionState is a step whose execution state is held back until its
+// outcome is known.
+type pendingExecutionState struct {
+	pc    uint64
+	op    OpCode
+	depth int
+}
+
+// holdExecutionState holds back the execution state of the step about to be
+// captured, until flushExecutionState is called with its outcome.
+func (evm *EVM) holdExecutionState(pc uint64, op OpCode) {
+	evm.pendingState = &pendingExecutionState{pc: pc, op: op, depth: evm.depth}
+}
+
+// flushExecutionState reports the execution state of the step held back, if
+// any, which ended with err: its error state, or the state of its opcode if err
+// is not an error of the step.
+func (evm *EVM) flushExecutionState(err error) {
+	step := evm.pendingState
+	if step == nil {
+		return
+	}
+	evm.pendingState = nil
+	state := errorExecutionState(step.op, err)
+	if state == 0 {
+		state = opExecutionState(step.op)
+	}
+	evm.interpreter.stateLogger.CaptureExecutionState(step.pc, step.op, state, step.depth)
+}
+
+// storesCode reports whether op ends the init code of a creation, whose
+// outcome depends on storing the returned code.
+func (evm *EVM) storesCode(op OpCode) bool {
+	return op == RETURN && len(evm.callStack) > 0 && evm.callStack[len(evm.callStack)-1].IsCreate
+}
+
+// opExecutionState returns the execution state of a successfully executed
+// opcode.
+func opExecutionState(op OpCode) ExecutionState {
+	switch {
+	case op.IsPush():
+		return ExecPush
+	case op >= DUP1 && op <= DUP16:
+		return ExecDup
+	case op >= SWAP1 && op <= SWAP16:
+		return ExecSwap
+	case op >= LOG0 && op <= LOG4:
+		return ExecLog
+	}
+	switch op {
+	case STOP:
+		return ExecStop
+	case ADD, SUB:
+		return ExecAddSub
+	case MUL, DIV, MOD:
+		return ExecMulDivMod
+	case SDIV, SMOD:
+		return ExecSdivSmod
+	case SHL:
+		return ExecShl
+	case SHR:
+		return ExecShr
+	case ADDMOD:
+		return ExecAddMod
+	case MULMOD:
+		return ExecMulMod
+	case EXP:
+		return ExecExp
+	case SIGNEXTEND:
+		return ExecSignExtend
+	case LT, GT, EQ:
+		return ExecCmp
+	case SLT, SGT:
+		return ExecScmp
+	case ISZERO:
+		return ExecIsZero
+	case AND, OR, XOR:
+		return ExecBitwise
+	case NOT:
+		return ExecNot
+	case BYTE:
+		return ExecByte
+	case SAR:
+		return ExecSar
+	case KECCAK256:
+		return ExecSha3
+	case ADDRESS:
+		return ExecAddress
+	case BALANCE:
+		return ExecBalance
+	case ORIGIN:
+		return ExecOrigin
+	case CALLER:
+		return ExecCaller
+	case CALLVALUE:
+		return ExecCallValue
+	case CALLDATALOAD:
+		return ExecCallDataLoad
+	case CALLDATASIZE:
+		return ExecCallDataSize
+	case CALLDATACOPY:
+		return ExecCallDataCopy
+	case CODESIZE:
+		return ExecCodeSize
+	case CODECOPY:
+		return ExecCodeCopy
+	case GASPRICE:
+		return ExecGasPrice
+	case EXTCODESIZE:
+		return ExecExtCodeSize
+	case EXTCODECOPY:
+		return ExecExtCodeCopy
+	case RETURNDATASIZE:
+		return ExecReturnDataSize
+	case RETURNDATACOPY:
+		return ExecReturnDataCopy
+	case EXTCODEHASH:
+		return ExecExtCodeHash
+	case BLOCKHASH:
+		return ExecBlockHash
+	case TIMESTAMP, NUMBER, GASLIMIT:
+		return ExecBlockCtxU64
+	case COINBASE:
+		return ExecBlockCtxU160
+	case DIFFICULTY, BASEFEE:
+		return ExecBlockCtxU256
+	case CHAINID:
+		return ExecChainID
+	case SELFBALANCE:
+		return ExecSelfBalance
+	case POP:
+		return ExecPop
+	case MLOAD, MSTORE, MSTORE8:
+		return ExecMemory
+	case SLOAD:
+		return ExecSload
+	case SSTORE:
+		return ExecSstore
+	case JUMP:
+		return ExecJump
+	case JUMPI:
+		return ExecJumpi
+	case PC:
+		return ExecPC
+	case MSIZE:
+		return ExecMsize
+	case GAS:
+		return ExecGas
+	case JUMPDEST:
+		return ExecJumpdest
+	case CREATE:
+		return ExecCreate
+	case CALL:
+		return ExecCall
+	case CALLCODE:
+		return ExecCallCode
+	case RETURN:
+		return ExecReturn
+	case DELEGATECALL:
+		return ExecDelegateCall
+	case CREATE2:
+		return ExecCreate2
+	case STATICCALL:
+		return ExecStaticCall
+	case REVERT:
+		return ExecRevert
+	case SELFDESTRUCT:
+		return ExecSelfDestruct
+	case PUSH0:
+		return ExecPush0
+	case MCOPY:
+		return ExecMcopy
+	case TLOAD:
+		return ExecTload
+	case TSTORE:
+		return ExecTstore
+	case BLOBHASH:
+		return ExecBlobHash
+	case BLOBBASEFEE:
+		return ExecBlobBaseFee
+	}
+	return ExecErrorInvalidOpcode
+}
+
+// outOfGasExecutionState returns the execution state of an opcode running out
+// of gas while paying for its dynamic gas cost.
+func outOfGasExecutionState(op OpCode) ExecutionState {
+	switch {
+	case op >= LOG0 && op <= LOG4:
+		return ExecErrorOutOfGasLog
+	}
+	switch op {
+	case MLOAD, MSTORE, MSTORE8:
+		return ExecErrorOutOfGasStaticMemoryExpansion
+	case RETURN, REVERT, CREATE:
+		return ExecErrorOutOfGasDynamicMemoryExpansion
+	case CALLDATACOPY, CODECOPY, RETURNDATACOPY, MCOPY:
+		return ExecErrorOutOfGasMemoryCopy
+	case BALANCE, EXTCODESIZE, EXTCODEHASH:
+		return ExecErrorOutOfGasAccountAccess
+	case EXTCODECOPY:
+		return ExecErrorOutOfGasExtCodeCopy
+	case EXP:
+		return ExecErrorOutOfGasExp
+	case KECCAK256:
+		return ExecErrorOutOfGasSha3
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		return ExecErrorOutOfGasCall
+	case SLOAD, SSTORE:
+		return ExecErrorOutOfGasSloadSstore
+	case CREATE2:
+		return ExecErrorOutOfGasCreate2
+	case SELFDESTRUCT:
+		return ExecErrorOutOfGasSelfDestruct
+	}
+	return ExecErrorOutOfGasConstant
+}
+
+// errorExecutionState returns the execution state of op failing with err,
+// including the failures of calls and creations detected by the EVM. Zero is
+// returned for errors that are not errors of the step, such as the REVERT of
+// the step.
+func errorExecutionState(op OpCode, err error) ExecutionState {
+	var (
+		underflow *ErrStackUnderflow
+		overflow  *ErrStackOverflow
+		invalid   *ErrInvalidOpCode
+		oog       *ErrOutOfGasKind
+	)
+	switch {
+	case errors.As(err, &underflow):
+		return ExecErrorStackUnderflow
+	case errors.As(err, &overflow):
+		return ExecErrorStackOverflow
+	case errors.As(err, &invalid):
+		return ExecErrorInvalidOpcode
+	case errors.Is(err, ErrInvalidJump):
+		return ExecErrorInvalidJump
+	case errors.Is(err, ErrWriteProtection):
+		return ExecErrorWriteProtection
+	case errors.Is(err, ErrReturnDataOutOfBounds):
+		return ExecErrorReturnDataOutOfBound
+	case errors.Is(err, ErrDepth):
+		return ExecErrorDepth
+	case errors.Is(err, ErrInsufficientBalance):
+		return ExecErrorInsufficientBalance
+	case errors.Is(err, ErrContractAddressCollision):
+		return ExecErrorContractAddressCollision
+	case errors.Is(err, ErrNonceUintOverflow):
+		return ExecErrorNonceUintOverflow
+	case errors.Is(err, ErrInvalidCode):
+		return ExecErrorInvalidCreationCode
+	case errors.Is(err, ErrMaxCodeSizeExceeded):
+		return ExecErrorMaxCodeSizeExceeded
+	case errors.Is(err, ErrMaxInitCodeSizeExceeded):
+		return ExecErrorMaxInitCodeSizeExceeded
+	case errors.Is(err, ErrCodeStoreOutOfGas):
+		return ExecErrorOutOfGasCodeStore
+	case errors.As(err, &oog) && oog.Cause == OutOfGasConstant:
+		return ExecErrorOutOfGasConstant
+	case errors.Is(err, ErrOutOfGas), errors.Is(err, ErrGasUintOverflow):
+		return outOfGasExecutionState(op)
+	}
+	return 0
+}
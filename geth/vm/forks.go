@@ -0,0 +1,255 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// Opcodes introduced by the forks after the merge.
+const (
+	MCOPY OpCode = 0x5e
+	PUSH0 OpCode = 0x5f
+)
+
+func init() {
+	for op, name := range map[OpCode]string{
+		MCOPY: "MCOPY",
+		PUSH0: "PUSH0",
+	} {
+		opCodeToString[op] = name
+		stringToOp[name] = op
+	}
+	activators[3855] = enable3855
+	activators[3860] = enable3860
+	activators[5656] = enable5656
+	activators[6780] = enable6780
+}
+
+var (
+	shanghaiInstructionSet = newShanghaiInstructionSet()
+	cancunInstructionSet   = newCancunInstructionSet()
+	pragueInstructionSet   = newPragueInstructionSet()
+)
+
+// newShanghaiInstructionSet returns the frontier, homestead, byzantium,
+// constantinople, istanbul, petersburg, berlin, london, merge and shanghai
+// instructions.
+func newShanghaiInstructionSet() JumpTable {
+	instructionSet := newMergeInstructionSet()
+	enable3855(&instructionSet) // PUSH0 instruction
+	enable3860(&instructionSet) // Limit and meter initcode
+	return validate(instructionSet)
+}
+
+// newCancunInstructionSet returns the instructions up to shanghai plus the
+// cancun ones.
+func newCancunInstructionSet() JumpTable {
+	instructionSet := newShanghaiInstructionSet()
+	enable1153(&instructionSet) // EIP-1153 "Transient Storage"
+	enable4844(&instructionSet) // EIP-4844 (BLOBHASH opcode)
+	enable5656(&instructionSet) // MCOPY instruction
+	enable6780(&instructionSet) // SELFDESTRUCT only in same transaction
+	enable7516(&instructionSet) // EIP-7516 (BLOBBASEFEE opcode)
+	return validate(instructionSet)
+}
+
+// newPragueInstructionSet returns the instructions up to cancun, with calls
+// following EIP-7702 delegations.
+func newPragueInstructionSet() JumpTable {
+	instructionSet := newCancunInstructionSet()
+	enable7702(&instructionSet) // EIP-7702 Set EOA account code
+	return validate(instructionSet)
+}
+
+// enable3855 applies EIP-3855 (PUSH0 instruction).
+func enable3855(jt *JumpTable) {
+	jt[PUSH0] = &operation{
+		execute:     opPush0,
+		constantGas: GasQuickStep,
+		minStack:    minStack(0, 1),
+		maxStack:    maxStack(0, 1),
+	}
+}
+
+// opPush0 implements the PUSH0 opcode.
+func opPush0(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	scope.Stack.push(new(uint256.Int))
+	return nil, nil
+}
+
+// enable3860 applies EIP-3860 (Limit and meter initcode).
+func enable3860(jt *JumpTable) {
+	// Replace rather than modify the operations, ExtraEips share them with
+	// the default instruction sets.
+	create, create2 := *jt[CREATE], *jt[CREATE2]
+	create.dynamicGas, create2.dynamicGas = gasCreateEip3860, gasCreate2Eip3860
+	jt[CREATE], jt[CREATE2] = &create, &create2
+}
+
+// initCodeGas returns the gas charged per word of the init code of CREATE and
+// CREATE2, failing if the init code is larger than allowed.
+func initCodeGas(stack *Stack) (uint64, error) {
+	size, overflow := stack.Back(2).Uint64WithOverflow()
+	if overflow || size > params.MaxInitCodeSize {
+		return 0, ErrMaxInitCodeSizeExceeded
+	}
+	// Since size <= params.MaxInitCodeSize, this multiplication cannot overflow
+	return params.InitCodeWordGas * toWordSize(size), nil
+}
+
+func gasCreateEip3860(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	gas, err := memoryGasCost(mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	moreGas, err := initCodeGas(stack)
+	if err != nil {
+		return 0, err
+	}
+	if gas, overflow := math.SafeAdd(gas, moreGas); !overflow {
+		return gas, nil
+	}
+	return 0, ErrGasUintOverflow
+}
+
+func gasCreate2Eip3860(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	gas, err := gasCreateEip3860(evm, contract, stack, mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	// Hashing the init code for the address, size is bounded by now
+	hashGas := params.Keccak256WordGas * toWordSize(stack.Back(2).Uint64())
+	if gas, overflow := math.SafeAdd(gas, hashGas); !overflow {
+		return gas, nil
+	}
+	return 0, ErrGasUintOverflow
+}
+
+// enable5656 applies EIP-5656 (MCOPY instruction).
+func enable5656(jt *JumpTable) {
+	jt[MCOPY] = &operation{
+		execute:     opMcopy,
+		constantGas: GasFastestStep,
+		dynamicGas:  gasMcopy,
+		minStack:    minStack(3, 0),
+		maxStack:    maxStack(3, 0),
+		memorySize:  memoryMcopy,
+	}
+}
+
+// gasMcopy charges the memory expansion and the copied words of MCOPY.
+var gasMcopy = memoryCopierGas(2)
+
+// memoryMcopy returns the memory needed by MCOPY, spanning the source and the
+// destination.
+func memoryMcopy(stack *Stack) (uint64, bool) {
+	mStart := stack.Back(0) // stack[0]: dest
+	if stack.Back(1).Gt(mStart) {
+		mStart = stack.Back(1) // stack[1]: source
+	}
+	return calcMemSize64(mStart, stack.Back(2)) // stack[2]: length
+}
+
+// opMcopy implements the MCOPY opcode. The regions may overlap, the copy
+// behaves as if through an intermediate buffer.
+func opMcopy(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	var (
+		dst    = scope.Stack.pop()
+		src    = scope.Stack.pop()
+		length = scope.Stack.pop()
+	)
+	// These values are checked for validity during the gas cost calculation
+	if length.IsZero() {
+		return nil, nil
+	}
+	store := scope.Memory.store
+	copy(store[dst.Uint64():], store[src.Uint64():src.Uint64()+length.Uint64()])
+	return nil, nil
+}
+
+// enable6780 applies EIP-6780 (SELFDESTRUCT only in same transaction).
+func enable6780(jt *JumpTable) {
+	jt[SELFDESTRUCT] = &operation{
+		execute:     opSelfdestruct6780,
+		dynamicGas:  gasSelfdestructEIP3529,
+		constantGas: params.SelfdestructGasEIP150,
+		minStack:    minStack(1, 0),
+		maxStack:    maxStack(1, 0),
+	}
+}
+
+// opSelfdestruct6780 implements SELFDESTRUCT as of EIP-6780: the balance is
+// always sent to the beneficiary, the account is only destructed if it was
+// created by the current transaction.
+func opSelfdestruct6780(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	if interpreter.readOnly {
+		return nil, ErrWriteProtection
+	}
+	var (
+		self        = scope.Contract.Address()
+		beneficiary = scope.Stack.pop()
+		balance     = interpreter.evm.StateDB.GetBalance(self)
+	)
+	interpreter.evm.StateDB.SubBalance(self, balance)
+	interpreter.evm.StateDB.AddBalance(beneficiary.Bytes20(), balance)
+	if interpreter.evm.createdInTx(self) {
+		interpreter.evm.StateDB.Suicide(self)
+	}
+	if interpreter.cfg.Debug {
+		interpreter.cfg.Tracer.CaptureEnter(SELFDESTRUCT, self, beneficiary.Bytes20(), []byte{}, 0, balance)
+		interpreter.cfg.Tracer.CaptureExit([]byte{}, 0, nil)
+	}
+	return nil, errStopToken
+}
+
+// markCreated records that the current transaction created addr, for the
+// SELFDESTRUCT semantics of EIP-6780.
+func (evm *EVM) markCreated(addr common.Address) {
+	if evm.createdContracts == nil {
+		evm.createdContracts = make(map[common.Address]struct{})
+	}
+	evm.createdContracts[addr] = struct{}{}
+}
+
+// createdInTx reports whether the current transaction created addr.
+func (evm *EVM) createdInTx(addr common.Address) bool {
+	_, ok := evm.createdContracts[addr]
+	return ok
+}
+
+// destructs reports whether a SELFDESTRUCT executed by addr deletes it: always
+// before EIP-6780, only if the current transaction created it as of it.
+func (evm *EVM) destructs(addr common.Address) bool {
+	if !evm.chainRules.IsCancun && !evm.interpreter.eipEnabled(6780) {
+		return true
+	}
+	return evm.createdInTx(addr)
+}
+
+// eipEnabled reports whether eip was enabled through Config.ExtraEips.
+func (in *EVMInterpreter) eipEnabled(eip int) bool {
+	for _, enabled := range in.cfg.ExtraEips {
+		if enabled == eip {
+			return true
+		}
+	}
+	return false
+}
@@ -18,6 +18,7 @@ package vm
 
 import (
 	"hash"
+	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
@@ -35,7 +36,16 @@ type Config struct {
 	NoBaseFee bool // Forces the EIP-1559 baseFee to 0 (needed for 0 price calls)
 	// * 启动Keccak SHA3的记录
 	// ![issue] 不太清楚这里的preimage指的是什么
-	EnablePreimageRecording bool // Enables recording of SHA3/keccak Enables recording of SHA3/keccak preimages
+	EnablePreimageRecording    bool // Enables recording of SHA3/keccak Enables recording of SHA3/keccak preimages
+	EnableBytecodeRecording    bool // Enables recording of every executed code for the bytecode table
+	EnableCopyEventRecording   bool // Enables recording of the bulk copies made by the EVM for the copy circuit
+	EnableKeccakInputRecording bool // Enables recording of the input of every keccak hash computed by the EVM
+
+	CircuitCapacityChecker *CircuitCapacityChecker // Aborts execution before a circuit of the block runs out of rows
+	ProvingCostSchedule    *ProvingCostSchedule    // Enables accounting of the proving cost of executions
+	Unsupported            *Unsupported            // Features the circuit can't prove, failing execution when hit
+	StepRecorder           *StepRecorder           // Records the deltas of every executed opcode
+	RevertErrors           RevertErrorRegistry     // Custom errors revert reasons are decoded against
 
 	// * EVM的指令表（instruction table），如果没set则自动补充
 	// * 目前是填opcodes？
@@ -81,6 +91,10 @@ type EVMInterpreter struct {
 
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	stateLogger ExecutionStateLogger // Tracer extension told the execution state of each step
+
+	steps uint64 // Steps executed, to poll the abort flag at a bounded granularity
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
@@ -90,6 +104,12 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 	// * 这里是一个default的JumpTable，装EVM的指令集？
 	if cfg.JumpTable == nil {
 		switch {
+		case evm.chainRules.IsPrague:
+			cfg.JumpTable = &pragueInstructionSet
+		case evm.chainRules.IsCancun:
+			cfg.JumpTable = &cancunInstructionSet
+		case evm.chainRules.IsShanghai:
+			cfg.JumpTable = &shanghaiInstructionSet
 		case evm.chainRules.IsMerge:
 			// * /vm/jump_table.go里面几乎定义了所有的指令集（一组opcode）
 			// ! 这玩意有什么作用呢？
@@ -123,13 +143,20 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 			cfg.JumpTable = &copy
 		}
 	}
+	if cfg.ProvingCostSchedule != nil {
+		cfg.JumpTable = cfg.ProvingCostSchedule.apply(cfg.JumpTable)
+	}
 
 	// * 返回一个interpreter实例
 	// * 如果填了指令集（jumpTable）就直接用，否则返回一个默认的
-	return &EVMInterpreter{
+	in := &EVMInterpreter{
 		evm: evm,
 		cfg: cfg,
 	}
+	if cfg.Debug {
+		in.stateLogger, _ = cfg.Tracer.(ExecutionStateLogger)
+	}
+	return in
 }
 
 // Run loops and evaluates the contract's code with the given input data and returns
@@ -166,6 +193,12 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	if len(contract.Code) == 0 {
 		return nil, nil
 	}
+	// Don't enter new frames once cancelled, the caller may not notice the
+	// abort before its next poll.
+	if atomic.LoadInt32(&in.evm.abort) != 0 {
+		in.evm.abortSeen = true
+		return nil, ErrExecutionAborted
+	}
 
 	// * 一堆重要的variables
 	var (
@@ -192,6 +225,11 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		logged  bool   // deferred EVMLogger should ignore already logged steps
 		// * opcode执行的结果
 		res []byte // result of the opcode execution function
+
+		recorder     = in.cfg.StepRecorder
+		stepIndex    = -1 // index of the current step in the recorder
+		stepPrepared bool // the current step reached execution
+		stepMemSize  int  // memory size before the current step
 	)
 	// Don't move this deferred function, it's placed before the capturestate-deferred method,
 	// so that it get's executed _after_: the capturestate needs the stacks before
@@ -201,13 +239,44 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	defer func() {
 		returnStack(stack)
 	}()
+	// Wrap errors with their location. Reverts and aborts are left alone as
+	// callers compare them directly; tracers get the bare error from the deferred
+	// methods below, which run before this one.
+	defer func() {
+		if err != nil && err != ErrExecutionReverted && err != ErrExecutionAborted {
+			err = newVMError(err, pc, op, in.evm.depth, contract)
+		}
+	}()
 	// * input data
 	contract.Input = input
 
+	if recorder != nil {
+		defer func() {
+			if err != nil && stepIndex >= 0 && !stepPrepared {
+				recorder.fail(stepIndex, cost, err)
+			}
+		}()
+	}
+
+	if checker := in.cfg.CircuitCapacityChecker; checker != nil {
+		if err = checker.addCode(contract.CodeHash, len(contract.Code)); err != nil {
+			return nil, err
+		}
+	}
+
 	// * 启动debugger
 	if in.cfg.Debug {
 		defer func() {
 			if err != nil {
+				if in.stateLogger != nil {
+					if !logged {
+						if state := errorExecutionState(op, err); state != 0 {
+							in.stateLogger.CaptureExecutionState(pcCopy, op, state, in.evm.depth)
+						}
+					} else {
+						in.evm.flushExecutionState(err)
+					}
+				}
 				if !logged {
 					in.cfg.Tracer.CaptureState(pcCopy, op, gasCopy, cost, callContext, in.returnData, in.evm.depth, err)
 				} else {
@@ -241,6 +310,13 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		op = contract.GetOp(pc)
 		operation := in.cfg.JumpTable[op]
 		cost = operation.constantGas // For tracing
+		if in.aborted() {
+			in.evm.abortSeen = true
+			return nil, ErrExecutionAborted
+		}
+		if recorder != nil {
+			stepIndex, stepPrepared, stepMemSize = recorder.start(pc, op, contract.Gas, in.evm.depth), false, mem.Len()
+		}
 
 		// Validate stack
 		// * 检测stack是否可用 -> items是否足够
@@ -255,9 +331,14 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			// ! 出现Error的位置 - overflow
 			return nil, &ErrStackOverflow{stackLen: sLen, limit: operation.maxStack}
 		}
+		if unsupported := in.cfg.Unsupported; unsupported != nil {
+			if err = unsupported.check(in.evm, op, pc, stack); err != nil {
+				return nil, err
+			}
+		}
 		// * 检查gas是否够用
 		if !contract.UseGas(cost) {
-			return nil, ErrOutOfGas
+			return nil, &ErrOutOfGasKind{Cause: OutOfGasConstant, Required: cost, Available: contract.Gas}
 		}
 		if operation.dynamicGas != nil {
 			// All ops with a dynamic memory usage also has a dynamic gas cost.
@@ -282,11 +363,22 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			var dynamicCost uint64
 			dynamicCost, err = operation.dynamicGas(in.evm, contract, stack, mem, memorySize)
 			cost += dynamicCost // for tracing
-			if err != nil || !contract.UseGas(dynamicCost) {
-				return nil, ErrOutOfGas
+			if err != nil {
+				// Oversized init code halts like running out of gas, but
+				// is told apart for the circuit.
+				if err == ErrMaxInitCodeSizeExceeded {
+					return nil, err
+				}
+				return nil, &ErrOutOfGasKind{Cause: outOfGasCause(op), Available: contract.Gas}
+			}
+			if !contract.UseGas(dynamicCost) {
+				return nil, &ErrOutOfGasKind{Cause: outOfGasCause(op), Required: dynamicCost, Available: contract.Gas}
 			}
 			// Do tracing before memory expansion
 			if in.cfg.Debug {
+				if in.stateLogger != nil {
+					in.evm.holdExecutionState(pc, op)
+				}
 				in.cfg.Tracer.CaptureState(pc, op, gasCopy, cost, callContext, in.returnData, in.evm.depth, err)
 				logged = true
 			}
@@ -294,11 +386,53 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 				mem.Resize(memorySize)
 			}
 		} else if in.cfg.Debug {
+			if in.stateLogger != nil {
+				in.evm.holdExecutionState(pc, op)
+			}
 			in.cfg.Tracer.CaptureState(pc, op, gasCopy, cost, callContext, in.returnData, in.evm.depth, err)
 			logged = true
 		}
+		if checker := in.cfg.CircuitCapacityChecker; checker != nil {
+			if err = checker.addStep(op, operation, stack); err != nil {
+				return nil, err
+			}
+		}
+		// Remember the memory layout of calls and creations for the callee's
+		// call context and copy events
+		if in.evm.tracksCallContexts() {
+			switch op {
+			case CALL, CALLCODE:
+				in.evm.callArgsTemp = callArgsFromStack(stack, 3)
+			case DELEGATECALL, STATICCALL:
+				in.evm.callArgsTemp = callArgsFromStack(stack, 2)
+			case CREATE, CREATE2:
+				in.evm.callArgsTemp = callArgs{inOffset: stack.Back(1).Uint64(), inSize: stack.Back(2).Uint64()}
+			}
+		}
+		var copyEvent *CopyEvent
+		if in.cfg.EnableCopyEventRecording {
+			copyEvent = in.prepareCopyEvent(op, callContext)
+		}
+		if in.cfg.EnableKeccakInputRecording {
+			in.recordOpKeccakInput(op, callContext)
+		}
+		if recorder != nil {
+			recorder.prepare(stepIndex, in.evm, operation, callContext, cost, stepMemSize)
+			stepPrepared = true
+		}
 		// execute the operation
 		res, err = operation.execute(&pc, in, callContext)
+		if recorder != nil {
+			recorder.finish(stepIndex, in.evm, operation, callContext, err)
+		}
+		// Calls and creations reported their state already, the end of init
+		// code is reported by create.
+		if in.stateLogger != nil && !in.evm.storesCode(op) {
+			in.evm.flushExecutionState(err)
+		}
+		if copyEvent != nil && (err == nil || err == errStopToken) {
+			in.finishCopyEvent(op, copyEvent, callContext)
+		}
 		if err != nil {
 			break
 		}
@@ -0,0 +1,80 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+)
+
+// KeccakInputs returns the input of every keccak hash computed by the EVM in
+// the current transaction, in the order they were computed. This covers the
+// KECCAK256 opcode, contract address derivation, init and deployed code hashes
+// and the code hashed by EXTCODEHASH. Inputs are only recorded if
+// Config.EnableKeccakInputRecording is set, and are cleared by Reset.
+func (evm *EVM) KeccakInputs() [][]byte {
+	return evm.keccakInputs
+}
+
+// recordKeccakInput remembers the input of a keccak hash.
+func (evm *EVM) recordKeccakInput(data []byte) {
+	if !evm.Config.EnableKeccakInputRecording {
+		return
+	}
+	evm.keccakInputs = append(evm.keccakInputs, common.CopyBytes(data))
+}
+
+// recordCreateAddressInput remembers the input hashed by crypto.CreateAddress.
+func (evm *EVM) recordCreateAddressInput(caller common.Address, nonce uint64) {
+	if !evm.Config.EnableKeccakInputRecording {
+		return
+	}
+	data, _ := rlp.EncodeToBytes([]interface{}{caller, nonce})
+	evm.keccakInputs = append(evm.keccakInputs, data)
+}
+
+// recordCreate2AddressInput remembers the input hashed by crypto.CreateAddress2.
+func (evm *EVM) recordCreate2AddressInput(caller common.Address, salt *uint256.Int, codeHash common.Hash) {
+	if !evm.Config.EnableKeccakInputRecording {
+		return
+	}
+	salt32 := salt.Bytes32()
+	data := make([]byte, 0, 1+common.AddressLength+2*common.HashLength)
+	data = append(data, 0xff)
+	data = append(data, caller.Bytes()...)
+	data = append(data, salt32[:]...)
+	data = append(data, codeHash.Bytes()...)
+	evm.keccakInputs = append(evm.keccakInputs, data)
+}
+
+// recordOpKeccakInput remembers the keccak input of op, if any. It's called by
+// the interpreter right before the operation executes, once its gas has been
+// paid for and its memory expanded.
+func (in *EVMInterpreter) recordOpKeccakInput(op OpCode, scope *ScopeContext) {
+	switch op {
+	case KECCAK256:
+		offset, size := scope.Stack.Back(0), scope.Stack.Back(1)
+		in.evm.keccakInputs = append(in.evm.keccakInputs, scope.Memory.GetCopy(int64(offset.Uint64()), int64(size.Uint64())))
+	case EXTCODEHASH:
+		// Empty accounts are answered with zero without hashing anything.
+		address := common.Address(scope.Stack.Back(0).Bytes20())
+		if !in.evm.StateDB.Empty(address) {
+			in.evm.keccakInputs = append(in.evm.keccakInputs, common.CopyBytes(in.evm.StateDB.GetCode(address)))
+		}
+	}
+}
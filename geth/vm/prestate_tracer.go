@@ -0,0 +1,302 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// AccessListLogger is an optional extension of EVMLogger, notified about the
+// access list entries a transaction is prepared with, before CaptureStart.
+type AccessListLogger interface {
+	CaptureAccessList(list types.AccessList)
+}
+
+// PrestateAccount is the state of an account reported by the PrestateTracer.
+// Storage only holds the slots touched by the transaction.
+type PrestateAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Nonce   uint64                      `json:"nonce,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+func (a *PrestateAccount) exists() bool {
+	return a.Nonce > 0 || len(a.Code) > 0 || len(a.Storage) > 0 || (a.Balance != nil && a.Balance.ToInt().Sign() != 0)
+}
+
+// PrestateDiff holds the pre and post state of the accounts changed by a
+// transaction. Accounts created by the transaction are only in Post, accounts
+// destructed by it only in Pre.
+type PrestateDiff struct {
+	Pre  map[common.Address]*PrestateAccount `json:"pre"`
+	Post map[common.Address]*PrestateAccount `json:"post"`
+}
+
+// PrestateTracer is an EVMLogger collecting every account and storage slot a
+// transaction touches, including the sender, the recipient, the coinbase and
+// the access list entries, for witness generation.
+//
+// In prestate mode the result is the state of everything touched before the
+// transaction. In diff mode it's the pre and post state of the fields that
+// changed. The PrestateTracer is meant to trace a single transaction.
+type PrestateTracer struct {
+	env        *EVM
+	diffMode   bool
+	pre        map[common.Address]*PrestateAccount
+	post       map[common.Address]*PrestateAccount
+	created    map[common.Address]bool
+	deleted    map[common.Address]bool
+	accessList types.AccessList
+}
+
+// NewPrestateTracer returns a new prestate tracer, reporting state changes if
+// diffMode is set.
+func NewPrestateTracer(diffMode bool) *PrestateTracer {
+	return &PrestateTracer{
+		diffMode: diffMode,
+		pre:      make(map[common.Address]*PrestateAccount),
+		post:     make(map[common.Address]*PrestateAccount),
+		created:  make(map[common.Address]bool),
+		deleted:  make(map[common.Address]bool),
+	}
+}
+
+// CaptureAccessList implements the AccessListLogger interface. The entries are
+// looked up once the EVM is known, in CaptureStart.
+func (t *PrestateTracer) CaptureAccessList(list types.AccessList) {
+	t.accessList = list
+}
+
+// CaptureTxStart implements the EVMLogger interface.
+func (t *PrestateTracer) CaptureTxStart(gasLimit uint64) {}
+
+// CaptureTxAccountWrite implements the TxStateLogger interface. The account is
+// looked up on its first write, with the written field set back to prev.
+func (t *PrestateTracer) CaptureTxAccountWrite(env *EVM, addr common.Address, field AccountFieldTag, value, prev common.Hash) {
+	t.env = env
+	if _, ok := t.pre[addr]; ok {
+		return
+	}
+	t.lookupAccount(addr)
+	switch field {
+	case AccountNonce:
+		t.pre[addr].Nonce = prev.Big().Uint64()
+	case AccountBalance:
+		t.pre[addr].Balance = (*hexutil.Big)(prev.Big())
+	}
+}
+
+// CaptureTxAccessListWrite implements the TxStateLogger interface. The entries
+// of the access list are looked up in CaptureStart.
+func (t *PrestateTracer) CaptureTxAccessListWrite(env *EVM, addr common.Address, slot *common.Hash, prev bool) {
+}
+
+// CaptureTxRefund implements the TxStateLogger interface.
+func (t *PrestateTracer) CaptureTxRefund(env *EVM, refund uint64) {}
+
+// CaptureStart implements the EVMLogger interface. By now the gas has been
+// bought and the nonce of the sender bumped, which CaptureTxAccountWrite saw
+// happen, and the value transferred, which is undone for the recipient.
+func (t *PrestateTracer) CaptureStart(env *EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+
+	_, known := t.pre[to]
+	t.lookupAccount(from)
+	t.lookupAccount(to)
+	t.lookupAccount(env.Context.Coinbase)
+	for _, tuple := range t.accessList {
+		t.lookupAccount(tuple.Address)
+		for _, key := range tuple.StorageKeys {
+			t.lookupStorage(tuple.Address, key)
+		}
+	}
+	// The sender was looked up before paying anything. Sending value to
+	// oneself leaves the balance unchanged.
+	if !known && to != from {
+		toBal := new(big.Int).Sub(t.pre[to].Balance.ToInt(), value)
+		t.pre[to].Balance = (*hexutil.Big)(toBal)
+	}
+
+	if create {
+		// The new account had neither code nor nonce, the collision check
+		// makes sure of it.
+		t.pre[to].Nonce, t.pre[to].Code = 0, nil
+		t.created[to] = true
+	}
+}
+
+// CaptureEnd implements the EVMLogger interface.
+func (t *PrestateTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+}
+
+// CaptureEnter implements the EVMLogger interface.
+func (t *PrestateTracer) CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+// CaptureExit implements the EVMLogger interface.
+func (t *PrestateTracer) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+// CaptureState implements the EVMLogger interface, looking up the accounts and
+// slots accessed by the step.
+func (t *PrestateTracer) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, rData []byte, depth int, err error) {
+	var (
+		stack    = scope.Stack
+		stackLen = stack.len()
+		caller   = scope.Contract.Address()
+	)
+	switch {
+	case stackLen >= 1 && (op == SLOAD || op == SSTORE):
+		t.lookupStorage(caller, stack.Back(0).Bytes32())
+
+	case stackLen >= 1 && (op == EXTCODECOPY || op == EXTCODEHASH || op == EXTCODESIZE || op == BALANCE || op == SELFDESTRUCT):
+		t.lookupAccount(stack.Back(0).Bytes20())
+		if op == SELFDESTRUCT && t.env.destructs(caller) {
+			t.deleted[caller] = true
+		}
+
+	case stackLen >= 5 && (op == DELEGATECALL || op == CALL || op == STATICCALL || op == CALLCODE):
+		t.lookupAccount(stack.Back(1).Bytes20())
+
+	case op == CREATE:
+		addr := crypto.CreateAddress(caller, t.env.StateDB.GetNonce(caller))
+		t.lookupAccount(addr)
+		t.created[addr] = true
+
+	case stackLen >= 4 && op == CREATE2:
+		// The memory isn't expanded yet, read the init code with padding.
+		offset, size := stack.Back(1), stack.Back(2)
+		init := getData(scope.Memory.Data(), offset.Uint64(), size.Uint64())
+		salt := stack.Back(3).Bytes32()
+		addr := crypto.CreateAddress2(caller, salt, crypto.Keccak256(init))
+		t.lookupAccount(addr)
+		t.created[addr] = true
+	}
+}
+
+// CaptureFault implements the EVMLogger interface.
+func (t *PrestateTracer) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, depth int, err error) {
+}
+
+// CaptureTxEnd implements the EVMLogger interface. In diff mode, the post state
+// is read once the coinbase has been paid and the sender refunded.
+func (t *PrestateTracer) CaptureTxEnd(restGas uint64) {
+	if !t.diffMode || t.env == nil {
+		return
+	}
+	for addr, state := range t.pre {
+		// Destructed accounts are kept in pre only.
+		if t.deleted[addr] {
+			continue
+		}
+		var (
+			modified = false
+			post     = &PrestateAccount{Storage: make(map[common.Hash]common.Hash)}
+			balance  = t.env.StateDB.GetBalance(addr)
+			nonce    = t.env.StateDB.GetNonce(addr)
+			code     = t.env.StateDB.GetCode(addr)
+		)
+		if balance.Cmp(state.Balance.ToInt()) != 0 {
+			modified = true
+			post.Balance = (*hexutil.Big)(new(big.Int).Set(balance))
+		}
+		if nonce != state.Nonce {
+			modified = true
+			post.Nonce = nonce
+		}
+		if !bytes.Equal(code, state.Code) {
+			modified = true
+			post.Code = common.CopyBytes(code)
+		}
+		for key, val := range state.Storage {
+			newVal := t.env.StateDB.GetState(addr, key)
+			if val == newVal {
+				// Unchanged slots are left out of both sides.
+				delete(state.Storage, key)
+				continue
+			}
+			modified = true
+			if val == (common.Hash{}) {
+				delete(state.Storage, key)
+			}
+			if newVal != (common.Hash{}) {
+				post.Storage[key] = newVal
+			}
+		}
+		if modified {
+			t.post[addr] = post
+		} else {
+			delete(t.pre, addr)
+		}
+	}
+	// Accounts created by the transaction had no pre state, unless they were
+	// funded in advance.
+	for addr := range t.created {
+		if state := t.pre[addr]; state != nil && !state.exists() {
+			delete(t.pre, addr)
+		}
+	}
+}
+
+// Prestate returns the pre state of everything touched, in prestate mode.
+func (t *PrestateTracer) Prestate() map[common.Address]*PrestateAccount {
+	return t.pre
+}
+
+// Diff returns the pre and post state of everything changed, in diff mode.
+func (t *PrestateTracer) Diff() *PrestateDiff {
+	return &PrestateDiff{Pre: t.pre, Post: t.post}
+}
+
+// GetResult returns the JSON encoded prestate or diff, depending on the mode.
+func (t *PrestateTracer) GetResult() (json.RawMessage, error) {
+	if t.diffMode {
+		return json.Marshal(t.Diff())
+	}
+	return json.Marshal(t.Prestate())
+}
+
+// lookupAccount records the current state of addr, unless it's already known.
+func (t *PrestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.pre[addr]; ok {
+		return
+	}
+	t.pre[addr] = &PrestateAccount{
+		Balance: (*hexutil.Big)(new(big.Int).Set(t.env.StateDB.GetBalance(addr))),
+		Nonce:   t.env.StateDB.GetNonce(addr),
+		Code:    common.CopyBytes(t.env.StateDB.GetCode(addr)),
+		Storage: make(map[common.Hash]common.Hash),
+	}
+}
+
+// lookupStorage records the current value of a slot of addr, unless it's
+// already known.
+func (t *PrestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+	if _, ok := t.pre[addr].Storage[key]; ok {
+		return
+	}
+	t.pre[addr].Storage[key] = t.env.StateDB.GetState(addr, key)
+}
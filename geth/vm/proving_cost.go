@@ -0,0 +1,153 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ProvingCost is the cost of proving an opcode or precompile execution: a
+// constant part plus PerUnit for every unit of data it processes. Units are
+// 32 byte words of input, except for EXP where they are bytes of exponent.
+type ProvingCost struct {
+	Constant uint64
+	PerUnit  uint64
+}
+
+// ProvingCostSchedule prices executions for the prover. Ops is indexed by
+// opcode, its costs are attached to the operations of the JumpTable.
+type ProvingCostSchedule struct {
+	Ops         [256]ProvingCost
+	Precompiles map[common.Address]ProvingCost
+}
+
+// DefaultProvingCostSchedule returns a schedule where every opcode costs one,
+// raised for the opcodes and precompiles that are expensive to prove.
+func DefaultProvingCostSchedule() *ProvingCostSchedule {
+	s := &ProvingCostSchedule{
+		Precompiles: map[common.Address]ProvingCost{
+			common.BytesToAddress([]byte{1}):    {Constant: 50000},                  // ecrecover
+			common.BytesToAddress([]byte{2}):    {Constant: 500, PerUnit: 100},      // sha256
+			common.BytesToAddress([]byte{3}):    {Constant: 500, PerUnit: 100},      // ripemd160
+			common.BytesToAddress([]byte{4}):    {Constant: 10, PerUnit: 1},         // identity
+			common.BytesToAddress([]byte{5}):    {Constant: 5000, PerUnit: 2000},    // modexp
+			common.BytesToAddress([]byte{6}):    {Constant: 5000},                   // bn256 add
+			common.BytesToAddress([]byte{7}):    {Constant: 50000},                  // bn256 scalar mul
+			common.BytesToAddress([]byte{8}):    {Constant: 100000, PerUnit: 30000}, // bn256 pairing
+			common.BytesToAddress([]byte{9}):    {Constant: 10000},                  // blake2f
+			common.BytesToAddress([]byte{0x0a}): {Constant: 200000},                 // point evaluation
+
+			common.BytesToAddress([]byte{0x0b}): {Constant: 5000},                   // bls12381 g1 add
+			common.BytesToAddress([]byte{0x0c}): {Constant: 20000, PerUnit: 10000},  // bls12381 g1 msm
+			common.BytesToAddress([]byte{0x0d}): {Constant: 10000},                  // bls12381 g2 add
+			common.BytesToAddress([]byte{0x0e}): {Constant: 40000, PerUnit: 12000},  // bls12381 g2 msm
+			common.BytesToAddress([]byte{0x0f}): {Constant: 150000, PerUnit: 20000}, // bls12381 pairing
+			common.BytesToAddress([]byte{0x10}): {Constant: 20000},                  // bls12381 map fp to g1
+			common.BytesToAddress([]byte{0x11}): {Constant: 60000},                  // bls12381 map fp2 to g2
+		},
+	}
+	for i := range s.Ops {
+		s.Ops[i] = ProvingCost{Constant: 1}
+	}
+	s.Ops[KECCAK256] = ProvingCost{Constant: 100, PerUnit: 50}
+	s.Ops[EXP] = ProvingCost{Constant: 10, PerUnit: 40}
+	s.Ops[MULMOD] = ProvingCost{Constant: 20}
+	s.Ops[ADDMOD] = ProvingCost{Constant: 10}
+	s.Ops[SDIV] = ProvingCost{Constant: 5}
+	s.Ops[SMOD] = ProvingCost{Constant: 5}
+	for _, op := range []OpCode{CALLDATACOPY, CODECOPY, EXTCODECOPY, RETURNDATACOPY, MCOPY, LOG0, LOG1, LOG2, LOG3, LOG4} {
+		s.Ops[op] = ProvingCost{Constant: 2, PerUnit: 2}
+	}
+	for _, op := range []OpCode{CREATE, CREATE2} {
+		s.Ops[op] = ProvingCost{Constant: 200, PerUnit: 60}
+	}
+	return s
+}
+
+// opCost returns the proving cost of op with the given stack.
+func (s *ProvingCostSchedule) opCost(op OpCode, stack *Stack) uint64 {
+	var units uint64
+	switch op {
+	case KECCAK256:
+		units = toWordSize(stack.Back(1).Uint64())
+	case EXP:
+		units = uint64(stack.Back(1).ByteLen())
+	case CALLDATACOPY, CODECOPY, RETURNDATACOPY, MCOPY:
+		units = toWordSize(stack.Back(2).Uint64())
+	case EXTCODECOPY:
+		units = toWordSize(stack.Back(3).Uint64())
+	case LOG0, LOG1, LOG2, LOG3, LOG4:
+		units = toWordSize(stack.Back(1).Uint64())
+	case CREATE, CREATE2:
+		units = toWordSize(stack.Back(2).Uint64())
+	}
+	cost := s.Ops[op]
+	return cost.Constant + cost.PerUnit*units
+}
+
+// apply returns a copy of jt whose operations account their proving cost
+// before executing. Operations are replaced rather than modified, the
+// instruction sets share them.
+func (s *ProvingCostSchedule) apply(jt *JumpTable) *JumpTable {
+	table := *jt
+	for i, op := range jt {
+		if op == nil {
+			continue
+		}
+		operation := *op
+		operation.execute = s.makeExecute(OpCode(i), op.execute)
+		table[i] = &operation
+	}
+	return &table
+}
+
+// makeExecute wraps the execution function of op, accounting its proving cost
+// against the stack it runs with.
+func (s *ProvingCostSchedule) makeExecute(op OpCode, execute executionFunc) executionFunc {
+	return func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+		interpreter.evm.addProvingCost(s.opCost(op, scope.Stack))
+		return execute(pc, interpreter, scope)
+	}
+}
+
+// precompileCost returns the proving cost of running the precompile at addr.
+func (s *ProvingCostSchedule) precompileCost(addr common.Address, input []byte) uint64 {
+	cost := s.Precompiles[addr]
+	return cost.Constant + cost.PerUnit*toWordSize(uint64(len(input)))
+}
+
+// ProvingCost returns the proving cost of everything executed by the EVM since
+// it was created, if Config.ProvingCostSchedule is set.
+func (evm *EVM) ProvingCost() uint64 {
+	return evm.provingCost
+}
+
+// addProvingCost accounts proving cost to the EVM and to the frame being
+// executed, whose call context is tracked whenever a schedule is set.
+func (evm *EVM) addProvingCost(cost uint64) {
+	evm.provingCost += cost
+	if len(evm.callStack) > 0 {
+		evm.currentCallContext().ProvingCost += cost
+	}
+}
+
+// addPrecompileProvingCost accounts the execution of a precompile.
+func (evm *EVM) addPrecompileProvingCost(addr common.Address, input []byte) {
+	if schedule := evm.Config.ProvingCostSchedule; schedule != nil {
+		evm.addProvingCost(schedule.precompileCost(addr, input))
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	// errorSelector is the selector of Error(string), used by require and revert.
+	errorSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+	// panicSelector is the selector of Panic(uint256), used by assert and
+	// checked arithmetic since Solidity 0.8.
+	panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+)
+
+// panicReasons are the descriptions of the panic codes raised by Solidity.
+var panicReasons = map[uint64]string{
+	0x00: "generic panic",
+	0x01: "assert(false)",
+	0x11: "arithmetic underflow or overflow",
+	0x12: "division or modulo by zero",
+	0x21: "enum overflow",
+	0x22: "invalid encoded storage byte array accessed",
+	0x31: "out-of-bounds array access; popping on an empty array",
+	0x32: "out-of-bounds access of an array or bytesN",
+	0x41: "out of memory",
+	0x51: "uninitialized function",
+}
+
+// RevertReasonKind tells how the data of a revert was encoded.
+type RevertReasonKind uint8
+
+const (
+	RevertReasonUnknown RevertReasonKind = iota // data not matching any known encoding
+	RevertReasonError                           // Error(string)
+	RevertReasonPanic                           // Panic(uint256)
+	RevertReasonCustom                          // custom error found in the registry
+)
+
+// RevertReason is the decoded data of a REVERT.
+type RevertReason struct {
+	Kind      RevertReasonKind
+	Message   string        // Message of Error(string)
+	PanicCode *big.Int      // Code of Panic(uint256)
+	Error     *abi.Error    // Definition of a custom error
+	Args      []interface{} // Arguments of a custom error
+	Data      []byte        // Raw revert data
+}
+
+// String returns a human readable description of the revert reason.
+func (r *RevertReason) String() string {
+	switch r.Kind {
+	case RevertReasonError:
+		return r.Message
+	case RevertReasonPanic:
+		if r.PanicCode.IsUint64() {
+			if reason, ok := panicReasons[r.PanicCode.Uint64()]; ok {
+				return fmt.Sprintf("%s (0x%x)", reason, r.PanicCode)
+			}
+		}
+		return fmt.Sprintf("unknown panic code: 0x%x", r.PanicCode)
+	case RevertReasonCustom:
+		args := make([]string, len(r.Args))
+		for i, arg := range r.Args {
+			args[i] = fmt.Sprint(arg)
+		}
+		return fmt.Sprintf("%s(%s)", r.Error.Name, strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("0x%x", r.Data)
+}
+
+// RevertErrorRegistry resolves custom errors by their selector.
+type RevertErrorRegistry map[[4]byte]abi.Error
+
+// NewRevertErrorRegistry returns a registry holding the given custom errors,
+// typically the Errors of a contract ABI.
+func NewRevertErrorRegistry(errs ...abi.Error) RevertErrorRegistry {
+	registry := make(RevertErrorRegistry, len(errs))
+	for _, e := range errs {
+		var selector [4]byte
+		copy(selector[:], e.ID[:4])
+		registry[selector] = e
+	}
+	return registry
+}
+
+// DecodeRevertReason decodes the data of a REVERT as Error(string),
+// Panic(uint256) or a custom error of the registry, which may be nil. It
+// returns nil if there is no data to decode.
+func DecodeRevertReason(data []byte, registry RevertErrorRegistry) *RevertReason {
+	if len(data) == 0 {
+		return nil
+	}
+	reason := &RevertReason{Data: common.CopyBytes(data)}
+	if message, ok := unpackErrorString(data); ok {
+		reason.Kind, reason.Message = RevertReasonError, message
+		return reason
+	}
+	if code, ok := unpackPanicCode(data); ok {
+		reason.Kind, reason.PanicCode = RevertReasonPanic, code
+		return reason
+	}
+	if len(data) >= 4 {
+		var selector [4]byte
+		copy(selector[:], data[:4])
+		if e, ok := registry[selector]; ok {
+			if args, err := e.Inputs.Unpack(data[4:]); err == nil {
+				reason.Kind, reason.Error, reason.Args = RevertReasonCustom, &e, args
+			}
+		}
+	}
+	return reason
+}
+
+// unpackErrorString decodes the ABI encoded string of Error(string) revert data.
+func unpackErrorString(data []byte) (string, bool) {
+	if len(data) < 4+64 || !bytes.Equal(data[:4], errorSelector) {
+		return "", false
+	}
+	data = data[4:]
+	offset := new(big.Int).SetBytes(data[:32])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(data))-32 {
+		return "", false
+	}
+	start := offset.Uint64() + 32
+	length := new(big.Int).SetBytes(data[start-32 : start])
+	if !length.IsUint64() || length.Uint64() > uint64(len(data))-start {
+		return "", false
+	}
+	return string(data[start : start+length.Uint64()]), true
+}
+
+// unpackPanicCode decodes the code of Panic(uint256) revert data.
+func unpackPanicCode(data []byte) (*big.Int, bool) {
+	if len(data) != 4+32 || !bytes.Equal(data[:4], panicSelector) {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(data[4:]), true
+}
+
+// decodeRevertReason returns a human readable revert reason for the data of a
+// reverted frame, or an empty string if the data is neither an Error(string)
+// nor a Panic(uint256).
+func decodeRevertReason(data []byte) string {
+	reason := DecodeRevertReason(data, nil)
+	if reason == nil || reason.Kind == RevertReasonUnknown {
+		return ""
+	}
+	return reason.String()
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+// SnapshotLogger is an optional extension of EVMLogger, notified whenever the
+// EVM takes a StateDB snapshot and whenever it reverts to one.
+type SnapshotLogger interface {
+	CaptureSnapshot(id int)
+	CaptureRevert(id int)
+}
+
+// rwJournalEntry is a reversible write recorded by the RwTracer.
+type rwJournalEntry struct {
+	index int // position of the write in RwTracer.ops
+}
+
+// rwRevision marks the journal length and the number of frames entered in the
+// transaction at the time a snapshot was taken.
+type rwRevision struct {
+	id     int
+	length int
+	frames int
+}
+
+// rwJournal mirrors the StateDB journal for the reversible writes emitted by
+// the RwTracer, so that reverting to a snapshot can be proven: every write made
+// since the snapshot is undone by a compensating write, latest first.
+type rwJournal struct {
+	entries   []rwJournalEntry
+	revisions []rwRevision
+}
+
+func (j *rwJournal) append(index int) {
+	j.entries = append(j.entries, rwJournalEntry{index: index})
+}
+
+func (j *rwJournal) snapshot(id int, frames int) {
+	j.revisions = append(j.revisions, rwRevision{id: id, length: len(j.entries), frames: frames})
+}
+
+// revert drops every revision taken after and including id and returns the
+// entries recorded since, in the order they were made, along with the number
+// of frames entered before the snapshot. The number is negative if there is no
+// such revision.
+func (j *rwJournal) revert(id int) ([]rwJournalEntry, int) {
+	for i := len(j.revisions) - 1; i >= 0; i-- {
+		if j.revisions[i].id != id {
+			continue
+		}
+		revision := j.revisions[i]
+		reverted := j.entries[revision.length:]
+		j.entries, j.revisions = j.entries[:revision.length], j.revisions[:i]
+		return reverted, revision.frames
+	}
+	return nil, -1
+}
+
+// truncate drops the entries of writes at or after the given operation index.
+func (j *rwJournal) truncate(index int) {
+	for len(j.entries) > 0 && j.entries[len(j.entries)-1].index >= index {
+		j.entries = j.entries[:len(j.entries)-1]
+	}
+}
+
+func (j *rwJournal) reset() {
+	j.entries, j.revisions = j.entries[:0], j.revisions[:0]
+}
+
+// CaptureSnapshot opens a journal revision for the snapshot.
+func (t *RwTracer) CaptureSnapshot(id int) {
+	t.journal.snapshot(id, len(t.txFrames))
+}
+
+// CaptureRevert emits the reversion of every reversible write made since the
+// snapshot, latest first. Every frame entered since the snapshot is reverted,
+// whether it made reversible writes or not: the frame being reverted ends its
+// reversion with the last compensating write, and frames that succeeded but
+// are reverted along with their caller end theirs once the writes made since
+// they were entered are compensated.
+func (t *RwTracer) CaptureRevert(id int) {
+	reverted, frames := t.journal.revert(id)
+	if frames < 0 {
+		return
+	}
+	length, counter := len(t.journal.entries), t.rwCounter
+	for i := len(reverted) - 1; i >= 0; i-- {
+		op := t.ops[reverted[i].index]
+		op.Value, op.ValuePrev = op.ValuePrev, op.Value
+		t.stamp(op)
+	}
+	for _, frame := range t.txFrames[frames:] {
+		// Frames reverted on their own earlier keep their reversion.
+		if frame.ctx == nil || frame.ctx.RwCounterEndOfReversion != 0 {
+			continue
+		}
+		undone := len(reverted)
+		if skipped := frame.journalStart - length; skipped > 0 {
+			undone -= skipped
+		}
+		if undone < 0 {
+			undone = 0
+		}
+		frame.ctx.RwCounterEndOfReversion = counter + uint64(undone)
+	}
+}
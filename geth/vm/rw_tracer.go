@@ -0,0 +1,754 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// RwTableTag is the tag of a read/write operation. The values are kept in sync
+// with the RwTableTag of the zkEVM circuit so operations can be assigned to the
+// rw table without any translation.
+type RwTableTag uint64
+
+const (
+	RwStart RwTableTag = iota + 1
+	RwStack
+	RwMemory
+	RwAccountStorage
+	RwTxAccessListAccount
+	RwTxAccessListAccountStorage
+	RwTxRefund
+	RwAccount
+	RwAccountDestructed
+	RwCallContext
+	RwTxLog
+	RwTxReceipt
+	RwTransientStorage
+)
+
+var rwTableTagToString = map[RwTableTag]string{
+	RwStart:                      "Start",
+	RwStack:                      "Stack",
+	RwMemory:                     "Memory",
+	RwAccountStorage:             "AccountStorage",
+	RwTxAccessListAccount:        "TxAccessListAccount",
+	RwTxAccessListAccountStorage: "TxAccessListAccountStorage",
+	RwTxRefund:                   "TxRefund",
+	RwAccount:                    "Account",
+	RwAccountDestructed:          "AccountDestructed",
+	RwCallContext:                "CallContext",
+	RwTxLog:                      "TxLog",
+	RwTxReceipt:                  "TxReceipt",
+	RwTransientStorage:           "TransientStorage",
+}
+
+func (t RwTableTag) String() string {
+	if s, ok := rwTableTagToString[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("RwTableTag(%d)", uint64(t))
+}
+
+// IsReversible returns whether writes with this tag have to be undone when the
+// call frame performing them fails.
+func (t RwTableTag) IsReversible() bool {
+	switch t {
+	case RwTxAccessListAccount, RwTxAccessListAccountStorage, RwTxRefund,
+		RwAccount, RwAccountStorage, RwAccountDestructed, RwTransientStorage:
+		return true
+	}
+	return false
+}
+
+// AccountFieldTag selects the account field touched by an RwAccount operation.
+type AccountFieldTag uint64
+
+const (
+	AccountNonce AccountFieldTag = iota + 1
+	AccountBalance
+	AccountCodeHash
+)
+
+// TxLogFieldTag selects the log field written by an RwTxLog operation.
+type TxLogFieldTag uint64
+
+const (
+	TxLogAddress TxLogFieldTag = iota + 1
+	TxLogTopic
+	TxLogData
+)
+
+// TxReceiptFieldTag selects the receipt field written by an RwTxReceipt operation.
+type TxReceiptFieldTag uint64
+
+const (
+	TxReceiptPostStateOrStatus TxReceiptFieldTag = iota + 1
+	TxReceiptCumulativeGasUsed
+	TxReceiptLogLength
+)
+
+// RwOperation is a single row of the rw table. The meaning of the key fields
+// depends on the tag, following the layout used by the circuit:
+//
+//   - ID is the call id for Stack, Memory and CallContext and the tx id for the
+//     tx scoped tags (access lists, refund, storage, transient storage,
+//     destructed, logs, receipts).
+//   - Address is the account address, or the emitting contract for TxLog.
+//   - FieldTag is the Account/CallContext/TxLog/TxReceipt field tag.
+//   - Key is the (transient) storage key, the stack pointer, the memory
+//     address or the log topic/data index.
+//
+// Aux carries the committed (tx start) value of AccountStorage operations.
+type RwOperation struct {
+	RwCounter uint64     `json:"rwCounter"`
+	IsWrite   bool       `json:"isWrite"`
+	Tag       RwTableTag `json:"tag"`
+
+	ID       uint64         `json:"id"`
+	Address  common.Address `json:"address"`
+	FieldTag uint64         `json:"fieldTag"`
+	Key      common.Hash    `json:"key"`
+
+	Value     common.Hash `json:"value"`
+	ValuePrev common.Hash `json:"valuePrev"`
+	Aux       common.Hash `json:"aux"`
+}
+
+// rwPending is a write whose counter is allocated when the step is captured,
+// but whose value is only known once the step has executed.
+type rwPending struct {
+	index int // position of the operation in RwTracer.ops
+	pos   int // stack position (from the bottom) to read the value from, -1 for refund
+}
+
+// rwFrame is the tracer's view of a call frame.
+type rwFrame struct {
+	callID       uint64
+	address      common.Address // address whose storage the frame operates on
+	isCreate     bool
+	pending      []rwPending
+	journalStart int // journal length when the frame was entered
+
+	ctx     *CallContext
+	ctxRows int // position of the frame's first CallContext operation
+}
+
+// callContextFields are the call context fields written when a frame is
+// entered, in order.
+var callContextFields = []CallContextFieldTag{
+	CallContextRwCounterEndOfReversion,
+	CallContextCallerID,
+	CallContextTxID,
+	CallContextDepth,
+	CallContextCallerAddress,
+	CallContextCalleeAddress,
+	CallContextCallDataOffset,
+	CallContextCallDataLength,
+	CallContextReturnDataOffset,
+	CallContextReturnDataLength,
+	CallContextValue,
+	CallContextIsSuccess,
+	CallContextIsPersistent,
+	CallContextIsStatic,
+	CallContextIsRoot,
+	CallContextIsCreate,
+	CallContextCodeSource,
+}
+
+// TxStateLogger is an optional extension of EVMLogger, told about the state
+// changes the state transition makes outside of the EVM: buying the gas, the
+// sender nonce bump, warming up the access list, applying EIP-7702
+// authorizations, reading the refund counter, refunding the sender and paying
+// the coinbase. None of them is reverted along with the message call.
+type TxStateLogger interface {
+	CaptureTxAccountWrite(env *EVM, addr common.Address, field AccountFieldTag, value, prev common.Hash)
+	CaptureTxAccessListWrite(env *EVM, addr common.Address, slot *common.Hash, prev bool)
+	CaptureTxRefund(env *EVM, refund uint64)
+}
+
+// CreateLogger is an optional extension of EVMLogger, told about the writes a
+// creation makes before opening the frame of the new contract: the nonce bump
+// of the creator and, as of berlin, the warm-up of the new address. They are
+// reported even if the creation then fails on an address collision, and come
+// before CaptureStart/CaptureEnter otherwise.
+type CreateLogger interface {
+	CaptureCreate(env *EVM, creator common.Address, nonce uint64, address common.Address, warm bool)
+}
+
+// RwTracer is an EVMLogger that records every read and write performed by the
+// EVM as an ordered list of rw_counter stamped operations, ready to be assigned
+// to the rw table of the zkEVM circuit.
+//
+// Memory operations are only emitted for MLOAD, MSTORE, MSTORE8 and MCOPY, which
+// copies within memory; other bulk copies are left to the copy circuit.
+type RwTracer struct {
+	env *EVM
+
+	rwCounter uint64
+	ops       []RwOperation
+	frames    []*rwFrame
+	txFrames  []*rwFrame // every frame of the transaction, in order of entry
+	journal   rwJournal
+
+	logID             uint64
+	gasLimit          uint64
+	stepOps           int    // length of ops before the current step
+	stepLogID         uint64 // log id before the current step
+	cumulativeGasUsed uint64
+	failed            bool
+}
+
+// NewRwTracer returns a tracer recording the rw operations of a transaction.
+// The transaction is identified by the TxID of the TxContext it runs with.
+func NewRwTracer() *RwTracer {
+	return &RwTracer{}
+}
+
+// Operations returns the recorded operations ordered by rw counter.
+func (t *RwTracer) Operations() []RwOperation {
+	return t.ops
+}
+
+// RwCounter returns the counter that will be assigned to the next operation.
+func (t *RwTracer) RwCounter() uint64 {
+	return t.rwCounter + 1
+}
+
+// Reset prepares the tracer for the next transaction of the block. The rw
+// counter and the cumulative gas keep running across transactions.
+func (t *RwTracer) Reset() {
+	t.frames = t.frames[:0]
+	t.txFrames = t.txFrames[:0]
+	t.journal.reset()
+	t.logID = 0
+	t.failed = false
+}
+
+// push appends an operation stamped with the next rw counter and returns its
+// position in the operation list. Reversible writes are journaled.
+func (t *RwTracer) push(op RwOperation) int {
+	index := t.stamp(op)
+	if op.IsWrite && op.Tag.IsReversible() {
+		t.journal.append(index)
+	}
+	return index
+}
+
+// stamp appends an operation stamped with the next rw counter, bypassing the
+// journal.
+func (t *RwTracer) stamp(op RwOperation) int {
+	t.rwCounter++
+	op.RwCounter = t.rwCounter
+	t.ops = append(t.ops, op)
+	return len(t.ops) - 1
+}
+
+// txID returns the index of the transaction being traced in the block.
+func (t *RwTracer) txID() uint64 {
+	return t.env.TxContext.TxID
+}
+
+func (t *RwTracer) frame() *rwFrame {
+	return t.frames[len(t.frames)-1]
+}
+
+func (t *RwTracer) enterFrame(address common.Address, isCreate bool) {
+	frame := &rwFrame{
+		callID:       t.rwCounter + 1,
+		address:      address,
+		isCreate:     isCreate,
+		journalStart: len(t.journal.entries),
+	}
+	t.frames = append(t.frames, frame)
+	t.txFrames = append(t.txFrames, frame)
+}
+
+func (t *RwTracer) exitFrame(err error) {
+	frame := t.frame()
+	if frame.isCreate && err == nil {
+		codeHash := t.env.StateDB.GetCodeHash(frame.address)
+		t.accountWrite(frame.address, AccountCodeHash, codeHash, emptyCodeHash)
+	}
+	t.frames = t.frames[:len(t.frames)-1]
+}
+
+func (t *RwTracer) CaptureTxStart(gasLimit uint64) {
+	t.gasLimit = gasLimit
+}
+
+func (t *RwTracer) CaptureTxEnd(restGas uint64) {
+	t.cumulativeGasUsed += t.gasLimit - restGas
+
+	status := uint64(1)
+	if t.failed {
+		status = 0
+	}
+	t.receiptWrite(TxReceiptPostStateOrStatus, status)
+	t.receiptWrite(TxReceiptCumulativeGasUsed, t.cumulativeGasUsed)
+	t.receiptWrite(TxReceiptLogLength, t.logID)
+}
+
+func (t *RwTracer) CaptureStart(env *EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	t.enterFrame(to, create)
+	t.captureFrameEntry(from, to, create, value)
+}
+
+func (t *RwTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	t.failed = err != nil
+	t.exitFrame(err)
+}
+
+func (t *RwTracer) CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	create := typ == CREATE || typ == CREATE2
+	switch typ {
+	case CALLCODE, DELEGATECALL:
+		// The callee code runs against the storage of the caller.
+		t.enterFrame(from, false)
+	default:
+		t.enterFrame(to, create)
+	}
+	if typ == CALL || create {
+		t.captureFrameEntry(from, to, create, value)
+	}
+}
+
+func (t *RwTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	t.exitFrame(err)
+}
+
+// captureFrameEntry records the state changes the EVM makes while opening a
+// frame, before any opcode of the callee runs: the nonce of the new contract
+// and the value transfer. The creator nonce bump is left to CaptureCreate.
+func (t *RwTracer) captureFrameEntry(from, to common.Address, create bool, value *big.Int) {
+	db := t.env.StateDB
+	if create && t.env.chainRules.IsEIP158 {
+		t.accountWrite(to, AccountNonce, uint64ToHash(1), common.Hash{})
+	}
+	if value == nil || value.Sign() == 0 {
+		return
+	}
+	fromBal, toBal := new(big.Int).Set(db.GetBalance(from)), db.GetBalance(to)
+	if from == to {
+		// The balance is back to where it was, the debit is recorded against
+		// the balance before the credit.
+		fromBal.Sub(fromBal, value)
+	}
+	t.accountWrite(from, AccountBalance, common.BigToHash(fromBal), common.BigToHash(new(big.Int).Add(fromBal, value)))
+	t.accountWrite(to, AccountBalance, common.BigToHash(toBal), common.BigToHash(new(big.Int).Sub(toBal, value)))
+}
+
+// CaptureCallContextEnter writes the call context of the frame just entered.
+// The outcome dependent fields are patched once the transaction is over.
+func (t *RwTracer) CaptureCallContextEnter(ctx *CallContext) {
+	frame := t.frame()
+	frame.ctx = ctx
+
+	var callerID uint64
+	if len(t.frames) > 1 {
+		callerID = t.frames[len(t.frames)-2].callID
+	}
+	for i, field := range callContextFields {
+		index := t.push(RwOperation{IsWrite: true, Tag: RwCallContext, ID: frame.callID,
+			FieldTag: uint64(field), Value: callContextValue(ctx, field, callerID)})
+		if i == 0 {
+			frame.ctxRows = index
+		}
+	}
+}
+
+// CaptureCallContextExit fills in the outcome of every frame of the transaction
+// once the root frame has exited.
+func (t *RwTracer) CaptureCallContextExit(ctx *CallContext) {
+	if !ctx.IsRoot {
+		return
+	}
+	for _, frame := range t.txFrames {
+		if frame.ctx == nil {
+			continue
+		}
+		for i, field := range callContextFields {
+			switch field {
+			case CallContextRwCounterEndOfReversion, CallContextIsSuccess, CallContextIsPersistent:
+				t.ops[frame.ctxRows+i].Value = callContextValue(frame.ctx, field, 0)
+			}
+		}
+	}
+}
+
+// callContextValue returns the value of a call context field as stored in the
+// rw table.
+func callContextValue(ctx *CallContext, field CallContextFieldTag, callerID uint64) common.Hash {
+	switch field {
+	case CallContextRwCounterEndOfReversion:
+		return uint64ToHash(ctx.RwCounterEndOfReversion)
+	case CallContextCallerID:
+		return uint64ToHash(callerID)
+	case CallContextTxID:
+		return uint64ToHash(ctx.TxID)
+	case CallContextDepth:
+		return uint64ToHash(uint64(ctx.Depth))
+	case CallContextCallerAddress:
+		return ctx.CallerAddress.Hash()
+	case CallContextCalleeAddress:
+		return ctx.CalleeAddress.Hash()
+	case CallContextCallDataOffset:
+		return uint64ToHash(ctx.CallDataOffset)
+	case CallContextCallDataLength:
+		return uint64ToHash(ctx.CallDataLength)
+	case CallContextReturnDataOffset:
+		return uint64ToHash(ctx.ReturnDataOffset)
+	case CallContextReturnDataLength:
+		return uint64ToHash(ctx.ReturnDataLength)
+	case CallContextValue:
+		return common.BigToHash(ctx.Value)
+	case CallContextIsSuccess:
+		return boolToHash(ctx.IsSuccess)
+	case CallContextIsPersistent:
+		return boolToHash(ctx.IsPersistent)
+	case CallContextIsStatic:
+		return boolToHash(ctx.IsStatic)
+	case CallContextIsRoot:
+		return boolToHash(ctx.IsRoot)
+	case CallContextIsCreate:
+		return boolToHash(ctx.IsCreate)
+	case CallContextCodeSource:
+		return ctx.CodeSource
+	}
+	return common.Hash{}
+}
+
+func (t *RwTracer) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, rData []byte, depth int, err error) {
+	frame := t.frame()
+	t.resolvePending(frame, scope)
+	t.stepOps, t.stepLogID = len(t.ops), t.logID
+	if err != nil {
+		// The step failed before executing, nothing was read or written.
+		return
+	}
+	var (
+		stack = scope.Stack
+		sLen  = stack.len()
+		db    = t.env.StateDB
+	)
+	switch {
+	case op >= DUP1 && op <= DUP16:
+		n := int(op - DUP1 + 1)
+		t.stackRead(frame, sLen-n, stack)
+		t.stackWrite(frame, sLen)
+		return
+
+	case op >= SWAP1 && op <= SWAP16:
+		n := int(op - SWAP1 + 1)
+		top, other := sLen-1, sLen-1-n
+		t.stackRead(frame, top, stack)
+		t.stackRead(frame, other, stack)
+		t.push(RwOperation{IsWrite: true, Tag: RwStack, ID: frame.callID, Key: stackPointer(top), Value: stackHash(stack, other)})
+		t.push(RwOperation{IsWrite: true, Tag: RwStack, ID: frame.callID, Key: stackPointer(other), Value: stackHash(stack, top)})
+		return
+	}
+
+	operation := t.env.interpreter.cfg.JumpTable[op]
+	pops := operation.minStack
+	pushes := int(params.StackLimit) + operation.minStack - operation.maxStack
+	for i := 0; i < pops; i++ {
+		t.stackRead(frame, sLen-1-i, stack)
+	}
+
+	switch op {
+	case MLOAD:
+		offset := stack.Back(0).Uint64()
+		for i := uint64(0); i < 32; i++ {
+			t.memoryOp(frame, false, offset+i, memoryByte(scope.Memory, offset+i))
+		}
+	case MSTORE:
+		offset := stack.Back(0).Uint64()
+		word := stack.Back(1).Bytes32()
+		for i := uint64(0); i < 32; i++ {
+			t.memoryOp(frame, true, offset+i, word[i])
+		}
+	case MSTORE8:
+		offset := stack.Back(0).Uint64()
+		t.memoryOp(frame, true, offset, byte(stack.Back(1).Uint64()))
+	case MCOPY:
+		// The source is read whole before the destination is written, the
+		// regions may overlap.
+		dst, src, length := stack.Back(0).Uint64(), stack.Back(1).Uint64(), stack.Back(2).Uint64()
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = memoryByte(scope.Memory, src+uint64(i))
+			t.memoryOp(frame, false, src+uint64(i), data[i])
+		}
+		for i, b := range data {
+			t.memoryOp(frame, true, dst+uint64(i), b)
+		}
+
+	case SLOAD:
+		key := common.Hash(stack.Back(0).Bytes32())
+		value := db.GetState(frame.address, key)
+		t.push(RwOperation{Tag: RwAccountStorage, ID: t.txID(), Address: frame.address, Key: key,
+			Value: value, ValuePrev: value, Aux: db.GetCommittedState(frame.address, key)})
+		t.accessListStorageWrite(frame.address, key)
+	case SSTORE:
+		key := common.Hash(stack.Back(0).Bytes32())
+		t.push(RwOperation{IsWrite: true, Tag: RwAccountStorage, ID: t.txID(), Address: frame.address, Key: key,
+			Value: common.Hash(stack.Back(1).Bytes32()), ValuePrev: db.GetState(frame.address, key),
+			Aux: db.GetCommittedState(frame.address, key)})
+		t.accessListStorageWrite(frame.address, key)
+		refund := db.GetRefund()
+		index := t.push(RwOperation{IsWrite: true, Tag: RwTxRefund, ID: t.txID(), ValuePrev: uint64ToHash(refund)})
+		frame.pending = append(frame.pending, rwPending{index: index, pos: -1})
+
+	case TLOAD:
+		key := common.Hash(stack.Back(0).Bytes32())
+		value := t.env.GetTransientState(frame.address, key)
+		t.push(RwOperation{Tag: RwTransientStorage, ID: t.txID(), Address: frame.address, Key: key,
+			Value: value, ValuePrev: value})
+	case TSTORE:
+		key := common.Hash(stack.Back(0).Bytes32())
+		t.push(RwOperation{IsWrite: true, Tag: RwTransientStorage, ID: t.txID(), Address: frame.address, Key: key,
+			Value: common.Hash(stack.Back(1).Bytes32()), ValuePrev: t.env.GetTransientState(frame.address, key)})
+
+	case BALANCE, EXTCODESIZE, EXTCODECOPY, EXTCODEHASH:
+		address := common.Address(stack.Back(0).Bytes20())
+		t.accessListAccountWrite(address)
+		if op == BALANCE {
+			t.accountRead(address, AccountBalance, common.BigToHash(db.GetBalance(address)))
+		} else {
+			t.accountRead(address, AccountCodeHash, db.GetCodeHash(address))
+		}
+	case SELFBALANCE:
+		t.accountRead(scope.Contract.Address(), AccountBalance, common.BigToHash(db.GetBalance(scope.Contract.Address())))
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		address := common.Address(stack.Back(1).Bytes20())
+		t.accessListAccountWrite(address)
+		// The gas of calls to a delegated account warms up the account it
+		// delegates to as well.
+		if target, ok := t.env.delegation(address); ok {
+			t.accessListAccountWrite(target)
+		}
+
+	case SELFDESTRUCT:
+		self := scope.Contract.Address()
+		beneficiary := common.Address(stack.Back(0).Bytes20())
+		t.accessListAccountWrite(beneficiary)
+		balance := db.GetBalance(self)
+		destructs := t.env.destructs(self)
+		switch {
+		case balance.Sign() == 0:
+		case beneficiary == self:
+			// The balance sent to itself is only lost if the account is
+			// destructed.
+			if destructs {
+				t.accountWrite(self, AccountBalance, common.Hash{}, common.BigToHash(balance))
+			}
+		default:
+			t.accountWrite(self, AccountBalance, common.Hash{}, common.BigToHash(balance))
+			prev := db.GetBalance(beneficiary)
+			t.accountWrite(beneficiary, AccountBalance, common.BigToHash(new(big.Int).Add(prev, balance)), common.BigToHash(prev))
+		}
+		if destructs {
+			t.push(RwOperation{IsWrite: true, Tag: RwAccountDestructed, ID: t.txID(), Address: self,
+				Value: boolToHash(true), ValuePrev: boolToHash(db.HasSuicided(self))})
+		}
+
+	case LOG0, LOG1, LOG2, LOG3, LOG4:
+		t.captureLog(op, scope)
+	}
+
+	for i := 0; i < pushes; i++ {
+		t.stackWrite(frame, sLen-pops+i)
+	}
+}
+
+func (t *RwTracer) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, depth int, err error) {
+	if err == ErrExecutionReverted {
+		// REVERT executed, its reads of the return data offset and size are
+		// part of the step.
+		return
+	}
+	// The step failed while executing (e.g. a write in a static context), so
+	// none of its writes took place. The circuit still proves the failure
+	// from the stack operands, which are read first: keep them and drop the
+	// rest. Failing steps never enter a child frame, hence the step's
+	// operations are the most recent ones.
+	keep := t.stepOps
+	for keep < len(t.ops) && t.ops[keep].Tag == RwStack && !t.ops[keep].IsWrite {
+		keep++
+	}
+	t.rwCounter -= uint64(len(t.ops) - keep)
+	t.ops = t.ops[:keep]
+	t.journal.truncate(keep)
+	t.logID = t.stepLogID
+	t.frame().pending = t.frame().pending[:0]
+}
+
+// CaptureTxAccountWrite records an account write of the state transition.
+func (t *RwTracer) CaptureTxAccountWrite(env *EVM, addr common.Address, field AccountFieldTag, value, prev common.Hash) {
+	t.env = env
+	t.persistentAccountWrite(addr, field, value, prev)
+}
+
+// CaptureTxAccessListWrite records the warm-up of an account, or of one of its
+// storage slots, by the state transition.
+func (t *RwTracer) CaptureTxAccessListWrite(env *EVM, addr common.Address, slot *common.Hash, prev bool) {
+	t.env = env
+	op := RwOperation{IsWrite: true, Tag: RwTxAccessListAccount, ID: t.txID(), Address: addr,
+		Value: boolToHash(true), ValuePrev: boolToHash(prev)}
+	if slot != nil {
+		op.Tag, op.Key = RwTxAccessListAccountStorage, *slot
+	}
+	t.stamp(op)
+}
+
+// CaptureTxRefund records the read of the refund counter at the end of the
+// transaction.
+func (t *RwTracer) CaptureTxRefund(env *EVM, refund uint64) {
+	t.env = env
+	t.stamp(RwOperation{Tag: RwTxRefund, ID: t.txID(), Value: uint64ToHash(refund), ValuePrev: uint64ToHash(refund)})
+}
+
+// CaptureCreate records the creator nonce bump and the warm-up of the new
+// address. They are journaled against the frame executing CREATE, whose
+// failure reverts them; those of a creation transaction are never reverted.
+func (t *RwTracer) CaptureCreate(env *EVM, creator common.Address, nonce uint64, address common.Address, warm bool) {
+	t.env = env
+	write := t.push
+	if len(t.frames) == 0 {
+		write = t.stamp
+	}
+	write(RwOperation{IsWrite: true, Tag: RwAccount, Address: creator, FieldTag: uint64(AccountNonce),
+		Value: uint64ToHash(nonce + 1), ValuePrev: uint64ToHash(nonce)})
+	if env.chainRules.IsBerlin {
+		write(RwOperation{IsWrite: true, Tag: RwTxAccessListAccount, ID: t.txID(), Address: address,
+			Value: boolToHash(true), ValuePrev: boolToHash(warm)})
+	}
+}
+
+// resolvePending fills in the values of the writes allocated by the previous
+// step of the frame, now that the step has executed.
+func (t *RwTracer) resolvePending(frame *rwFrame, scope *ScopeContext) {
+	for _, p := range frame.pending {
+		if p.pos < 0 {
+			t.ops[p.index].Value = uint64ToHash(t.env.StateDB.GetRefund())
+		} else {
+			t.ops[p.index].Value = stackHash(scope.Stack, p.pos)
+		}
+	}
+	frame.pending = frame.pending[:0]
+}
+
+func (t *RwTracer) captureLog(op OpCode, scope *ScopeContext) {
+	var (
+		stack   = scope.Stack
+		address = scope.Contract.Address()
+		offset  = stack.Back(0).Uint64()
+		size    = stack.Back(1).Uint64()
+		topics  = int(op - LOG0)
+	)
+	t.logID++
+	t.push(RwOperation{IsWrite: true, Tag: RwTxLog, ID: t.txID(), Address: address,
+		FieldTag: uint64(TxLogAddress), Key: uint64ToHash(t.logID), Value: address.Hash()})
+	for i := 0; i < topics; i++ {
+		t.push(RwOperation{IsWrite: true, Tag: RwTxLog, ID: t.txID(), Address: address,
+			FieldTag: uint64(TxLogTopic), Key: uint64ToHash(uint64(i)), Value: stackHash(stack, stack.len()-3-i)})
+	}
+	for i := uint64(0); i < size; i++ {
+		b := memoryByte(scope.Memory, offset+i)
+		t.memoryOp(t.frame(), false, offset+i, b)
+		t.push(RwOperation{IsWrite: true, Tag: RwTxLog, ID: t.txID(), Address: address,
+			FieldTag: uint64(TxLogData), Key: uint64ToHash(i), Value: uint64ToHash(uint64(b))})
+	}
+}
+
+func (t *RwTracer) stackRead(frame *rwFrame, pos int, stack *Stack) {
+	t.push(RwOperation{Tag: RwStack, ID: frame.callID, Key: stackPointer(pos), Value: stackHash(stack, pos)})
+}
+
+// stackWrite allocates a stack write whose value is resolved at the next step.
+func (t *RwTracer) stackWrite(frame *rwFrame, pos int) {
+	index := t.push(RwOperation{IsWrite: true, Tag: RwStack, ID: frame.callID, Key: stackPointer(pos)})
+	frame.pending = append(frame.pending, rwPending{index: index, pos: pos})
+}
+
+func (t *RwTracer) memoryOp(frame *rwFrame, isWrite bool, address uint64, b byte) {
+	t.push(RwOperation{IsWrite: isWrite, Tag: RwMemory, ID: frame.callID, Key: uint64ToHash(address), Value: uint64ToHash(uint64(b))})
+}
+
+func (t *RwTracer) accountRead(address common.Address, field AccountFieldTag, value common.Hash) {
+	t.push(RwOperation{Tag: RwAccount, Address: address, FieldTag: uint64(field), Value: value, ValuePrev: value})
+}
+
+func (t *RwTracer) accountWrite(address common.Address, field AccountFieldTag, value, prev common.Hash) {
+	t.push(RwOperation{IsWrite: true, Tag: RwAccount, Address: address, FieldTag: uint64(field), Value: value, ValuePrev: prev})
+}
+
+// persistentAccountWrite records an account write made before the snapshot of
+// the frame was taken, which survives the failure of the frame.
+func (t *RwTracer) persistentAccountWrite(address common.Address, field AccountFieldTag, value, prev common.Hash) {
+	t.stamp(RwOperation{IsWrite: true, Tag: RwAccount, Address: address, FieldTag: uint64(field), Value: value, ValuePrev: prev})
+}
+
+func (t *RwTracer) accessListAccountWrite(address common.Address) {
+	t.push(RwOperation{IsWrite: true, Tag: RwTxAccessListAccount, ID: t.txID(), Address: address,
+		Value: boolToHash(true), ValuePrev: boolToHash(t.env.StateDB.AddressInAccessList(address))})
+}
+
+func (t *RwTracer) accessListStorageWrite(address common.Address, key common.Hash) {
+	_, warm := t.env.StateDB.SlotInAccessList(address, key)
+	t.push(RwOperation{IsWrite: true, Tag: RwTxAccessListAccountStorage, ID: t.txID(), Address: address, Key: key,
+		Value: boolToHash(true), ValuePrev: boolToHash(warm)})
+}
+
+func (t *RwTracer) receiptWrite(field TxReceiptFieldTag, value uint64) {
+	t.push(RwOperation{IsWrite: true, Tag: RwTxReceipt, ID: t.txID(), FieldTag: uint64(field), Value: uint64ToHash(value)})
+}
+
+// stackPointer converts a stack position counted from the bottom into the
+// circuit's stack pointer, which starts at 1024 and decreases on push.
+func stackPointer(pos int) common.Hash {
+	return uint64ToHash(uint64(int(params.StackLimit) - 1 - pos))
+}
+
+func stackHash(stack *Stack, pos int) common.Hash {
+	return common.Hash(stack.Data()[pos].Bytes32())
+}
+
+func memoryByte(mem *Memory, address uint64) byte {
+	if address < uint64(mem.Len()) {
+		return mem.Data()[address]
+	}
+	return 0
+}
+
+func uint64ToHash(v uint64) common.Hash {
+	return common.Hash(new(uint256.Int).SetUint64(v).Bytes32())
+}
+
+func boolToHash(b bool) common.Hash {
+	if b {
+		return uint64ToHash(1)
+	}
+	return common.Hash{}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// TestRwTracerSelfDestructToSelf checks the balance writes of a contract
+// sending its balance to itself on SELFDESTRUCT: the balance is emptied only if
+// the account is destructed, which as of EIP-6780 it isn't unless created by
+// the transaction.
+func TestRwTracerSelfDestructToSelf(t *testing.T) {
+	var (
+		self    = common.HexToAddress("0xaa")
+		balance = big.NewInt(100)
+	)
+	for _, tt := range []struct {
+		name       string
+		eips       []int
+		destructed bool
+	}{
+		{"destructed", nil, true},
+		{"not destructed", []int{6780}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			statedb.SetCode(self, []byte{byte(ADDRESS), byte(SELFDESTRUCT)})
+			statedb.SetBalance(self, balance)
+
+			var (
+				tracer = NewRwTracer()
+				vmctx  = BlockContext{
+					CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+					Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+					BlockNumber: big.NewInt(1),
+					Time:        big.NewInt(1),
+				}
+				evm = NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{Debug: true, Tracer: tracer, ExtraEips: tt.eips})
+			)
+			if _, _, err := evm.Call(AccountRef(common.Address{}), self, nil, 100000, new(big.Int)); err != nil {
+				t.Fatalf("call failed: %v", err)
+			}
+			var (
+				writes     []RwOperation
+				destructed bool
+			)
+			for _, op := range tracer.Operations() {
+				switch {
+				case op.IsWrite && op.Tag == RwAccount && op.Address == self && op.FieldTag == uint64(AccountBalance):
+					writes = append(writes, op)
+				case op.Tag == RwAccountDestructed && op.Address == self:
+					destructed = true
+				}
+			}
+			if destructed != tt.destructed {
+				t.Fatalf("destructed mismatch: have %t, want %t", destructed, tt.destructed)
+			}
+			if !tt.destructed {
+				if len(writes) != 0 {
+					t.Fatalf("balance writes: have %d, want none", len(writes))
+				}
+				return
+			}
+			if len(writes) != 1 {
+				t.Fatalf("balance writes: have %d, want 1", len(writes))
+			}
+			if want := common.BigToHash(balance); writes[0].Value != (common.Hash{}) || writes[0].ValuePrev != want {
+				t.Fatalf("balance write mismatch: have %x (prev %x), want 0 (prev %x)", writes[0].Value, writes[0].ValuePrev, want)
+			}
+		})
+	}
+}
+
+// TestRwTracerRevertWithoutWrites checks that every reverted frame ends its
+// reversion, including a callee that succeeded without making any reversible
+// write before its caller reverted.
+func TestRwTracerRevertWithoutWrites(t *testing.T) {
+	var (
+		caller = common.HexToAddress("0xaa")
+		callee = common.HexToAddress("0xbb")
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(caller, []byte{
+		byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0,
+		byte(PUSH1), 0xbb, byte(GAS), byte(CALL), byte(POP),
+		byte(PUSH1), 0, byte(PUSH1), 0, byte(REVERT),
+	})
+	statedb.SetCode(callee, []byte{byte(STOP)})
+
+	var (
+		tracer = NewRwTracer()
+		vmctx  = BlockContext{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: big.NewInt(1),
+			Time:        big.NewInt(1),
+		}
+		evm = NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{Debug: true, Tracer: tracer})
+	)
+	if _, _, err := evm.Call(AccountRef(common.Address{}), caller, nil, 100000, new(big.Int)); err != ErrExecutionReverted {
+		t.Fatalf("call error mismatch: have %v, want %v", err, ErrExecutionReverted)
+	}
+	ctxs := evm.CallContexts()
+	if len(ctxs) != 2 {
+		t.Fatalf("call contexts: have %d, want 2", len(ctxs))
+	}
+	root, inner := ctxs[0], ctxs[1]
+	if !inner.IsSuccess || inner.IsPersistent {
+		t.Fatalf("callee outcome mismatch: success %t, persistent %t", inner.IsSuccess, inner.IsPersistent)
+	}
+	if root.RwCounterEndOfReversion == 0 || inner.RwCounterEndOfReversion == 0 {
+		t.Fatalf("end of reversion unset: caller %d, callee %d", root.RwCounterEndOfReversion, inner.RwCounterEndOfReversion)
+	}
+	if inner.RwCounterEndOfReversion > root.RwCounterEndOfReversion {
+		t.Fatalf("callee ends its reversion after its caller: callee %d, caller %d", inner.RwCounterEndOfReversion, root.RwCounterEndOfReversion)
+	}
+}
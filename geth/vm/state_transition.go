@@ -19,6 +19,8 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
@@ -33,6 +35,48 @@ import (
 
 var emptyCodeHash = crypto.Keccak256Hash(nil)
 
+var (
+	// ErrBlobTxCreate is returned if a blob transaction has no explicit to field.
+	ErrBlobTxCreate = errors.New("blob transaction of type create")
+
+	// ErrMissingBlobHashes is returned if a blob transaction carries no blob
+	// hashes.
+	ErrMissingBlobHashes = errors.New("blob transaction missing blob hashes")
+
+	// ErrInvalidBlobHash is returned if a blob hash is not a KZG versioned hash.
+	ErrInvalidBlobHash = errors.New("invalid blob hash version")
+
+	// ErrTooManyBlobs is returned if a blob transaction exceeds the blob gas of
+	// a block.
+	ErrTooManyBlobs = errors.New("blob transaction exceeds max blob gas per block")
+
+	// ErrBlobFeeCapTooLow is returned if the blob fee cap of a transaction is
+	// lower than the blob base fee of the block.
+	ErrBlobFeeCapTooLow = errors.New("max fee per blob gas less than block blob gas fee")
+
+	// ErrMissingExcessBlobGas is returned if a transaction is executed post
+	// cancun in a block context without excess blob gas.
+	ErrMissingExcessBlobGas = errors.New("missing excess blob gas")
+
+	// ErrSetCodeTxCreate is returned if a set code transaction has no explicit
+	// to field.
+	ErrSetCodeTxCreate = errors.New("set code transaction of type create")
+
+	// ErrEmptyAuthList is returned if a set code transaction carries an empty
+	// authorization list.
+	ErrEmptyAuthList = errors.New("set code transaction with empty auth list")
+)
+
+// Errors of invalid EIP-7702 authorizations. They do not invalidate the
+// transaction, the authorization is skipped.
+var (
+	ErrAuthorizationWrongChainID       = errors.New("authorization chain id mismatch")
+	ErrAuthorizationNonceOverflow      = errors.New("authorization nonce overflow")
+	ErrAuthorizationInvalidSignature   = errors.New("authorization has invalid signature")
+	ErrAuthorizationDestinationHasCode = errors.New("authorization destination has code")
+	ErrAuthorizationNonceMismatch      = errors.New("authorization nonce does not match current account nonce")
+)
+
 /*
 The State Transitioning Model
 
@@ -79,14 +123,23 @@ type Message interface {
 	IsFake() bool
 	Data() []byte
 	AccessList() types.AccessList
+
+	BlobGasFeeCap() *big.Int
+	BlobHashes() []common.Hash
+
+	AuthorizationList() []types.SetCodeAuthorization
 }
 
 // ExecutionResult includes all output after executing given evm
-// message no matter the execution itself is successful or not.
+// message no matter the execution itself is successful or not. Errors of
+// the interpreter loop are wrapped in a *vm.VMError locating the failing step.
 type ExecutionResult struct {
 	UsedGas    uint64 // Total used gas but include the refunded gas
 	Err        error  // Any error encountered during the execution(listed in core/vm/errors.go)
 	ReturnData []byte // Returned data from evm(function result or data supplied with revert opcode)
+
+	ProvingCost  uint64           // Proving cost of the execution, if the EVM has a proving cost schedule
+	RevertReason *vm.RevertReason // Decoded revert data, if the execution reverted with data
 }
 
 // Unwrap returns the internal evm error which allows us for further
@@ -117,7 +170,7 @@ func (result *ExecutionResult) Revert() []byte {
 }
 
 // IntrinsicGas computes the 'intrinsic gas' for a message with the given data.
-func IntrinsicGas(data []byte, accessList types.AccessList, isContractCreation bool, isHomestead, isEIP2028 bool) (uint64, error) {
+func IntrinsicGas(data []byte, accessList types.AccessList, authList []types.SetCodeAuthorization, isContractCreation bool, isHomestead, isEIP2028, isEIP3860 bool) (uint64, error) {
 	// Set the starting gas for the raw transaction
 	var gas uint64
 	if isContractCreation && isHomestead {
@@ -149,14 +202,33 @@ func IntrinsicGas(data []byte, accessList types.AccessList, isContractCreation b
 			return 0, ErrGasUintOverflow
 		}
 		gas += z * params.TxDataZeroGas
+
+		if isContractCreation && isEIP3860 {
+			lenWords := toWordSize(uint64(len(data)))
+			if (math.MaxUint64-gas)/params.InitCodeWordGas < lenWords {
+				return 0, ErrGasUintOverflow
+			}
+			gas += lenWords * params.InitCodeWordGas
+		}
 	}
 	if accessList != nil {
 		gas += uint64(len(accessList)) * params.TxAccessListAddressGas
 		gas += uint64(accessList.StorageKeys()) * params.TxAccessListStorageKeyGas
 	}
+	if authList != nil {
+		gas += uint64(len(authList)) * params.CallNewAccountGas
+	}
 	return gas, nil
 }
 
+// toWordSize returns the ceiled word size required for init code payment calculation.
+func toWordSize(size uint64) uint64 {
+	if size > math.MaxUint64-31 {
+		return math.MaxUint64/32 + 1
+	}
+	return (size + 31) / 32
+}
+
 // NewStateTransition initialises and returns a new state transition object.
 func NewStateTransition(evm *vm.EVM, msg Message, gp *GasPool) *StateTransition {
 	return &StateTransition{
@@ -183,6 +255,29 @@ func ApplyMessage(evm *vm.EVM, msg Message, gp *GasPool) (*ExecutionResult, erro
 	return NewStateTransition(evm, msg, gp).TransitionDb()
 }
 
+// ApplyMessageWithContext is ApplyMessage, stopped once ctx is done. The
+// message is then reported failed with an *vm.ErrExecutionCancelled, matching
+// vm.ErrExecutionAborted, and the gas used until the cancellation.
+//
+// A cancelled message is applied like any failed one: the changes of its
+// execution are reverted, but the nonce of the sender is bumped and the gas
+// used is paid for, to the coinbase. Callers discarding cancelled messages
+// should snapshot the state beforehand.
+func ApplyMessageWithContext(ctx context.Context, evm *vm.EVM, msg Message, gp *GasPool) (*ExecutionResult, error) {
+	stop := evm.CancelOnDone(ctx)
+	result, err := ApplyMessage(evm, msg, gp)
+	stop()
+	if err != nil {
+		return nil, err
+	}
+	// An aborted frame may have been swallowed by its caller, the execution
+	// error alone doesn't tell.
+	if result.Err == vm.ErrExecutionAborted || evm.Aborted() {
+		result.Err = vm.CancelledError(ctx, result.UsedGas)
+	}
+	return result, nil
+}
+
 // to returns the recipient of the message.
 func (st *StateTransition) to() common.Address {
 	if st.msg == nil || st.msg.To() == nil /* contract creation */ {
@@ -191,15 +286,42 @@ func (st *StateTransition) to() common.Address {
 	return *st.msg.To()
 }
 
+// blobGasUsed returns the blob gas consumed by the blobs of the message.
+func (st *StateTransition) blobGasUsed() uint64 {
+	return uint64(len(st.msg.BlobHashes())) * params.BlobTxBlobGasPerBlob
+}
+
+// isCancun reports whether the message is applied with the cancun rules.
+func (st *StateTransition) isCancun() bool {
+	return st.evm.ChainConfig().IsCancun(st.evm.Context.BlockNumber, st.evm.Context.Time.Uint64())
+}
+
+// isPrague reports whether the message is applied with the prague rules.
+func (st *StateTransition) isPrague() bool {
+	return st.evm.ChainConfig().IsPrague(st.evm.Context.BlockNumber, st.evm.Context.Time.Uint64())
+}
+
 func (st *StateTransition) buyGas() error {
 	mgval := new(big.Int).SetUint64(st.msg.Gas())
 	mgval = mgval.Mul(mgval, st.gasPrice)
-	balanceCheck := mgval
+	balanceCheck := new(big.Int).Set(mgval)
 	if st.gasFeeCap != nil {
 		balanceCheck = new(big.Int).SetUint64(st.msg.Gas())
 		balanceCheck = balanceCheck.Mul(balanceCheck, st.gasFeeCap)
 		balanceCheck.Add(balanceCheck, st.value)
 	}
+	// The blob gas is bought at the blob base fee and burnt, the balance must
+	// cover it at the blob fee cap.
+	if st.isCancun() {
+		if blobGas := new(big.Int).SetUint64(st.blobGasUsed()); blobGas.Sign() > 0 {
+			if blobFeeCap := st.msg.BlobGasFeeCap(); blobFeeCap != nil {
+				balanceCheck.Add(balanceCheck, new(big.Int).Mul(blobGas, blobFeeCap))
+			}
+			if blobBaseFee := st.evm.Context.BlobBaseFee(); blobBaseFee != nil {
+				mgval.Add(mgval, new(big.Int).Mul(blobGas, blobBaseFee))
+			}
+		}
+	}
 	if have, want := st.state.GetBalance(st.msg.From()), balanceCheck; have.Cmp(want) < 0 {
 		return fmt.Errorf("%w: address %v have %v want %v", ErrInsufficientFunds, st.msg.From().Hex(), have, want)
 	}
@@ -230,11 +352,13 @@ func (st *StateTransition) preCheck() error {
 			return fmt.Errorf("%w: address %v, nonce: %d", ErrNonceMax,
 				st.msg.From().Hex(), stNonce)
 		}
-		// Make sure the sender is an EOA
+		// Make sure the sender is an EOA, possibly delegating its code (EIP-7702)
 		// * 确定sender是一个EOA（外部账户，个人账户）
 		if codeHash := st.state.GetCodeHash(st.msg.From()); codeHash != emptyCodeHash && codeHash != (common.Hash{}) {
-			return fmt.Errorf("%w: address %v, codehash: %s", ErrSenderNoEOA,
-				st.msg.From().Hex(), codeHash)
+			if _, delegated := vm.ParseDelegation(st.state.GetCode(st.msg.From())); !delegated {
+				return fmt.Errorf("%w: address %v, codehash: %s", ErrSenderNoEOA,
+					st.msg.From().Hex(), codeHash)
+			}
 		}
 	}
 	// Make sure that transaction gasFeeCap is greater than the baseFee (post london)
@@ -262,6 +386,55 @@ func (st *StateTransition) preCheck() error {
 			}
 		}
 	}
+	// Check the blobs of the transaction (EIP-4844)
+	if hashes := st.msg.BlobHashes(); hashes != nil {
+		if st.msg.To() == nil {
+			return ErrBlobTxCreate
+		}
+		if len(hashes) == 0 {
+			return ErrMissingBlobHashes
+		}
+		for i, hash := range hashes {
+			if hash[0] != params.BlobTxHashVersion {
+				return fmt.Errorf("%w: blob %d, version %d", ErrInvalidBlobHash, i, hash[0])
+			}
+		}
+		if blobGas := st.blobGasUsed(); blobGas > params.MaxBlobGasPerBlock {
+			return fmt.Errorf("%w: address %v, blob gas %d, limit %d", ErrTooManyBlobs,
+				st.msg.From().Hex(), blobGas, params.MaxBlobGasPerBlock)
+		}
+	}
+	// Check the authorizations of the transaction (EIP-7702)
+	if auths := st.msg.AuthorizationList(); auths != nil {
+		if !st.isPrague() {
+			return fmt.Errorf("%w: set code transaction before prague", ErrTxTypeNotSupported)
+		}
+		if st.msg.To() == nil {
+			return fmt.Errorf("%w: address %v", ErrSetCodeTxCreate, st.msg.From().Hex())
+		}
+		if len(auths) == 0 {
+			return fmt.Errorf("%w: address %v", ErrEmptyAuthList, st.msg.From().Hex())
+		}
+	}
+	// Make sure that the block has an excess blob gas (post cancun)
+	blobBaseFee := st.evm.Context.BlobBaseFee()
+	if st.isCancun() && blobBaseFee == nil {
+		return ErrMissingExcessBlobGas
+	}
+	// Make sure that the blob fee cap covers the blob base fee (post cancun)
+	if st.isCancun() && st.blobGasUsed() > 0 {
+		blobFeeCap := st.msg.BlobGasFeeCap()
+		if blobFeeCap == nil {
+			blobFeeCap = new(big.Int)
+		}
+		// Skip the check if the fee cap is zero and baseFee was explicitly disabled (eth_call)
+		if !st.evm.Config.NoBaseFee || blobFeeCap.BitLen() > 0 {
+			if blobFeeCap.Cmp(blobBaseFee) < 0 {
+				return fmt.Errorf("%w: address %v, maxFeePerBlobGas: %v, blobBaseFee: %v", ErrBlobFeeCapTooLow,
+					st.msg.From().Hex(), blobFeeCap, blobBaseFee)
+			}
+		}
+	}
 	return st.buyGas()
 }
 
@@ -308,6 +481,23 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 	// * 3）caller有足够的balance去覆盖资产转移（value transfer），还有个**topmost** call（不知道是什么）
 
 	// Check clauses 1-3, buy gas if everything is correct
+	// Transactions overflowing a circuit may have to be rejected, which needs
+	// the state from before the gas purchase.
+	var (
+		checker     = st.evm.Config.CircuitCapacityChecker
+		snapshot    int
+		provingCost = st.evm.ProvingCost()
+	)
+	if checker != nil {
+		checker.BeginTx()
+		snapshot = st.evm.Snapshot()
+	}
+	// The gas is bought before the tracer is told about the transaction, keep
+	// the balance it is paid from.
+	var balance *big.Int
+	if st.txLogger() != nil {
+		balance = new(big.Int).Set(st.state.GetBalance(st.msg.From()))
+	}
 	if err := st.preCheck(); err != nil {
 		return nil, err
 	}
@@ -318,16 +508,20 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 			st.evm.Config.Tracer.CaptureTxEnd(st.gas)
 		}()
 	}
+	if logger := st.txLogger(); logger != nil {
+		logger.CaptureTxAccountWrite(st.evm, st.msg.From(), vm.AccountBalance,
+			common.BigToHash(st.state.GetBalance(st.msg.From())), common.BigToHash(balance))
+	}
 
 	var (
 		msg              = st.msg
 		sender           = vm.AccountRef(msg.From())
-		rules            = st.evm.ChainConfig().Rules(st.evm.Context.BlockNumber, st.evm.Context.Random != nil)
+		rules            = st.evm.ChainConfig().Rules(st.evm.Context.BlockNumber, st.evm.Context.Random != nil, st.evm.Context.Time.Uint64())
 		contractCreation = msg.To() == nil
 	)
 
 	// Check clauses 4-5, subtract intrinsic gas if everything is correct
-	gas, err := IntrinsicGas(st.data, st.msg.AccessList(), contractCreation, rules.IsHomestead, rules.IsIstanbul)
+	gas, err := IntrinsicGas(st.data, st.msg.AccessList(), st.msg.AuthorizationList(), contractCreation, rules.IsHomestead, rules.IsIstanbul, rules.IsShanghai)
 	if err != nil {
 		return nil, err
 	}
@@ -341,22 +535,68 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFundsForTransfer, msg.From().Hex())
 	}
 
+	// Check whether the init code size has been exceeded.
+	if rules.IsShanghai && contractCreation && len(st.data) > params.MaxInitCodeSize {
+		return nil, fmt.Errorf("%w: code size %v limit %v", vm.ErrMaxInitCodeSizeExceeded, len(st.data), params.MaxInitCodeSize)
+	}
+
 	// Set up the initial access list.
 	if rules.IsBerlin {
-		st.state.PrepareAccessList(msg.From(), msg.To(), vm.ActivePrecompiles(rules), msg.AccessList())
+		st.state.PrepareAccessList(msg.From(), msg.To(), vm.PrecompiledAddresses(rules), msg.AccessList())
+		// The coinbase is warm from the start as of EIP-3651.
+		if rules.IsShanghai {
+			st.state.AddAddressToAccessList(st.evm.Context.Coinbase)
+		}
+		if logger := st.txLogger(); logger != nil {
+			st.captureAccessList(logger, rules)
+		}
+		if st.evm.Config.Debug {
+			if logger, ok := st.evm.Config.Tracer.(vm.AccessListLogger); ok {
+				logger.CaptureAccessList(msg.AccessList())
+			}
+		}
+	}
+	if !contractCreation {
+		// Increment the nonce for the next transaction, before applying the
+		// authorizations, which the sender may have signed itself.
+		st.setNonce(msg.From(), st.state.GetNonce(sender.Address())+1)
+	}
+	if auths := msg.AuthorizationList(); rules.IsPrague && auths != nil {
+		// Invalid authorizations are skipped, they don't invalidate the
+		// transaction.
+		for i := range auths {
+			st.applyAuthorization(&auths[i])
+		}
+		// The account the recipient delegates to is warm from the start.
+		if target, ok := vm.ParseDelegation(st.state.GetCode(st.to())); ok {
+			st.addAddressToAccessList(target)
+		}
 	}
 	var (
 		ret   []byte
 		vmerr error // vm errors do not effect consensus and are therefore not assigned to err
 	)
-	if contractCreation {
+	if checker != nil {
+		// The begin and end steps alone may overflow a circuit, the message
+		// isn't executed then.
+		vmerr = checker.AddTx(st.accessListRows(rules))
+	}
+	if vmerr != nil {
+		st.gas = 0
+	} else if contractCreation {
 		ret, _, st.gas, vmerr = st.evm.Create(sender, st.data, st.gas, st.value)
 	} else {
-		// Increment the nonce for the next transaction
-		st.state.SetNonce(msg.From(), st.state.GetNonce(sender.Address())+1)
 		// ![issue] 调用Call的位置
 		ret, st.gas, vmerr = st.evm.Call(sender, st.to(), st.data, st.gas, st.value)
 	}
+	if checker != nil {
+		if checker.Reject && errors.Is(vmerr, vm.ErrCircuitCapacityExceeded) {
+			st.evm.RevertToSnapshot(snapshot)
+			st.gp.AddGas(st.initialGas)
+			return nil, vmerr
+		}
+		checker.CommitTx()
+	}
 
 	if !rules.IsLondon {
 		// Before EIP-3529: refunds were capped to gasUsed / 2
@@ -377,33 +617,205 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 	} else {
 		fee := new(big.Int).SetUint64(st.gasUsed())
 		fee.Mul(fee, effectiveTip)
-		st.state.AddBalance(st.evm.Context.Coinbase, fee)
+		st.addBalance(st.evm.Context.Coinbase, fee)
 	}
 
+	// Decode the revert reason. The error is left as the bare sentinel, callers
+	// compare it directly.
+	var reason *vm.RevertReason
+	if vmerr == vm.ErrExecutionReverted {
+		reason = vm.DecodeRevertReason(ret, st.evm.Config.RevertErrors)
+	}
+	// Transient storage only lives for the transaction.
+	if rules.IsCancun {
+		st.evm.ClearTransientStorage()
+	}
 	return &ExecutionResult{
-		UsedGas:    st.gasUsed(),
-		Err:        vmerr,
-		ReturnData: ret,
+		UsedGas:      st.gasUsed(),
+		Err:          vmerr,
+		ReturnData:   ret,
+		ProvingCost:  st.evm.ProvingCost() - provingCost,
+		RevertReason: reason,
 	}, nil
 }
 
+// validateAuthorization checks an EIP-7702 authorization against the state,
+// returning the account that signed it.
+func (st *StateTransition) validateAuthorization(auth *types.SetCodeAuthorization) (authority common.Address, err error) {
+	// The chain ID must be zero or the one of the current chain
+	if chainID := auth.ChainID.ToBig(); chainID.Sign() != 0 && chainID.Cmp(st.evm.ChainConfig().ChainID) != 0 {
+		return authority, ErrAuthorizationWrongChainID
+	}
+	// Limit the nonce to 2^64-1 per EIP-2681
+	if auth.Nonce+1 < auth.Nonce {
+		return authority, ErrAuthorizationNonceOverflow
+	}
+	authority, err = auth.Authority()
+	if err != nil {
+		return authority, fmt.Errorf("%w: %v", ErrAuthorizationInvalidSignature, err)
+	}
+	// The authority is warm even if the authorization turns out invalid
+	st.addAddressToAccessList(authority)
+
+	// The authority must have no code but a delegation, and the nonce of the
+	// authorization.
+	code := st.state.GetCode(authority)
+	if _, ok := vm.ParseDelegation(code); len(code) != 0 && !ok {
+		return authority, ErrAuthorizationDestinationHasCode
+	}
+	if have := st.state.GetNonce(authority); have != auth.Nonce {
+		return authority, fmt.Errorf("%w: have %d, want %d", ErrAuthorizationNonceMismatch, have, auth.Nonce)
+	}
+	return authority, nil
+}
+
+// applyAuthorization writes the delegation designator of a valid EIP-7702
+// authorization into the code of its authority.
+func (st *StateTransition) applyAuthorization(auth *types.SetCodeAuthorization) error {
+	authority, err := st.validateAuthorization(auth)
+	if err != nil {
+		return err
+	}
+	// The intrinsic gas assumed a new account, refund the difference if the
+	// authority already exists.
+	if st.state.Exist(authority) {
+		st.state.AddRefund(params.CallNewAccountGas - params.TxAuthTupleGas)
+	}
+	st.setNonce(authority, auth.Nonce+1)
+	if auth.Address == (common.Address{}) {
+		// Delegating to the zero address clears the delegation.
+		st.setCode(authority, nil)
+		return nil
+	}
+	st.setCode(authority, vm.AddressToDelegation(auth.Address))
+	return nil
+}
+
 func (st *StateTransition) refundGas(refundQuotient uint64) {
 	// Apply refund counter, capped to a refund quotient
 	refund := st.gasUsed() / refundQuotient
 	if refund > st.state.GetRefund() {
 		refund = st.state.GetRefund()
 	}
+	if logger := st.txLogger(); logger != nil {
+		logger.CaptureTxRefund(st.evm, st.state.GetRefund())
+	}
 	st.gas += refund
 
 	// Return ETH for remaining gas, exchanged at the original rate.
 	remaining := new(big.Int).Mul(new(big.Int).SetUint64(st.gas), st.gasPrice)
-	st.state.AddBalance(st.msg.From(), remaining)
+	st.addBalance(st.msg.From(), remaining)
 
 	// Also return remaining gas to the block gas counter so it is
 	// available for the next transaction.
 	st.gp.AddGas(st.gas)
 }
 
+// txLogger returns the tracer if it is interested in the state changes made
+// outside of the EVM.
+func (st *StateTransition) txLogger() vm.TxStateLogger {
+	if !st.evm.Config.Debug {
+		return nil
+	}
+	logger, _ := st.evm.Config.Tracer.(vm.TxStateLogger)
+	return logger
+}
+
+// setNonce sets the nonce of addr, telling the tracer about the write.
+func (st *StateTransition) setNonce(addr common.Address, nonce uint64) {
+	prev := st.state.GetNonce(addr)
+	st.state.SetNonce(addr, nonce)
+	if logger := st.txLogger(); logger != nil {
+		logger.CaptureTxAccountWrite(st.evm, addr, vm.AccountNonce,
+			common.BigToHash(new(big.Int).SetUint64(nonce)), common.BigToHash(new(big.Int).SetUint64(prev)))
+	}
+}
+
+// setCode sets the code of addr, telling the tracer about the write of its code
+// hash.
+func (st *StateTransition) setCode(addr common.Address, code []byte) {
+	prev := st.state.GetCodeHash(addr)
+	st.state.SetCode(addr, code)
+	if logger := st.txLogger(); logger != nil {
+		hash := emptyCodeHash
+		if len(code) != 0 {
+			hash = crypto.Keccak256Hash(code)
+		}
+		logger.CaptureTxAccountWrite(st.evm, addr, vm.AccountCodeHash, hash, prev)
+	}
+}
+
+// addAddressToAccessList warms addr up, telling the tracer about the write.
+func (st *StateTransition) addAddressToAccessList(addr common.Address) {
+	prev := st.state.AddressInAccessList(addr)
+	st.state.AddAddressToAccessList(addr)
+	if logger := st.txLogger(); logger != nil {
+		logger.CaptureTxAccessListWrite(st.evm, addr, nil, prev)
+	}
+}
+
+// addBalance credits addr, telling the tracer about the write.
+func (st *StateTransition) addBalance(addr common.Address, amount *big.Int) {
+	prev := new(big.Int).Set(st.state.GetBalance(addr))
+	st.state.AddBalance(addr, amount)
+	if logger := st.txLogger(); logger != nil {
+		logger.CaptureTxAccountWrite(st.evm, addr, vm.AccountBalance,
+			common.BigToHash(st.state.GetBalance(addr)), common.BigToHash(prev))
+	}
+}
+
+// captureAccessList tells the tracer about the accounts and slots the access
+// list starts with, in the order PrepareAccessList adds them, along with
+// whether they were warm already.
+func (st *StateTransition) captureAccessList(logger vm.TxStateLogger, rules params.Rules) {
+	var (
+		msg   = st.msg
+		addrs = []common.Address{msg.From()}
+		warm  = make(map[common.Address]map[common.Hash]bool)
+	)
+	addAddress := func(addr common.Address) {
+		_, ok := warm[addr]
+		logger.CaptureTxAccessListWrite(st.evm, addr, nil, ok)
+		if !ok {
+			warm[addr] = make(map[common.Hash]bool)
+		}
+	}
+	if msg.To() != nil {
+		addrs = append(addrs, *msg.To())
+	}
+	for _, addr := range append(addrs, vm.PrecompiledAddresses(rules)...) {
+		addAddress(addr)
+	}
+	for _, tuple := range msg.AccessList() {
+		addAddress(tuple.Address)
+		for _, key := range tuple.StorageKeys {
+			key := key
+			logger.CaptureTxAccessListWrite(st.evm, tuple.Address, &key, warm[tuple.Address][key])
+			warm[tuple.Address][key] = true
+		}
+	}
+	if rules.IsShanghai {
+		addAddress(st.evm.Context.Coinbase)
+	}
+}
+
+// accessListRows returns the number of accounts and slots the access list of
+// the message starts with.
+func (st *StateTransition) accessListRows(rules params.Rules) uint64 {
+	if !rules.IsBerlin {
+		return 0
+	}
+	list := st.msg.AccessList()
+	rows := uint64(1 + len(vm.PrecompiledAddresses(rules)) + len(list) + list.StorageKeys())
+	if st.msg.To() != nil {
+		rows++
+	}
+	if rules.IsShanghai {
+		rows++
+	}
+	return rows
+}
+
 // gasUsed returns the amount of gas used up by the state transition.
 func (st *StateTransition) gasUsed() uint64 {
 	return st.initialGas - st.gas
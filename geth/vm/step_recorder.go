@@ -0,0 +1,148 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// StorageAccess is a storage slot read or written by a step. Prev is only set
+// for writes and holds the value the slot had before.
+type StorageAccess struct {
+	Key   common.Hash
+	Value common.Hash
+	Prev  common.Hash
+}
+
+// Step is the record of an opcode executed by the interpreter. It only holds
+// what changed during the step, the full machine state at any step can be
+// rebuilt by replaying the deltas of the steps before it.
+//
+// Steps failing before they execute, e.g. for lack of gas or stack items, are
+// recorded with their error and without deltas.
+type Step struct {
+	PC       uint64
+	Op       OpCode
+	Depth    int
+	Gas      uint64 // Gas available before the step
+	GasCost  uint64 // Gas charged by the interpreter for the step
+	GasAfter uint64 // Gas available after the step, including gas returned by calls
+	Refund   uint64 // Refund counter after the step
+
+	Pops         []uint256.Int // Stack items consumed, top first
+	Pushes       []uint256.Int // Stack items produced, top first
+	MemoryGrowth uint64        // Bytes the memory was expanded by
+
+	StorageReads  []StorageAccess
+	StorageWrites []StorageAccess
+
+	Err error
+}
+
+// StepRecorder records a Step for every opcode executed by the interpreter it's
+// configured in through Config.StepRecorder. Recorded steps must not be
+// modified. The recorder is not thread safe.
+type StepRecorder struct {
+	steps []Step
+}
+
+// NewStepRecorder returns an empty step recorder.
+func NewStepRecorder() *StepRecorder {
+	return new(StepRecorder)
+}
+
+// Steps returns the steps recorded so far, in execution order.
+func (r *StepRecorder) Steps() []Step {
+	return r.steps
+}
+
+// Reset drops the recorded steps.
+func (r *StepRecorder) Reset() {
+	r.steps = nil
+}
+
+// start records a new step, before its gas is charged, and returns its index.
+func (r *StepRecorder) start(pc uint64, op OpCode, gas uint64, depth int) int {
+	r.steps = append(r.steps, Step{PC: pc, Op: op, Depth: depth, Gas: gas})
+	return len(r.steps) - 1
+}
+
+// prepare captures the pre-execution deltas of a step whose gas has been
+// charged and memory expanded.
+func (r *StepRecorder) prepare(index int, evm *EVM, operation *operation, scope *ScopeContext, cost uint64, memSize int) {
+	step := &r.steps[index]
+	step.GasCost = cost
+	step.MemoryGrowth = uint64(scope.Memory.Len() - memSize)
+
+	if n, _ := stackDelta(step.Op, operation); n > 0 {
+		step.Pops = make([]uint256.Int, n)
+		for i := range step.Pops {
+			step.Pops[i] = *scope.Stack.Back(i)
+		}
+	}
+	if step.Op == SSTORE {
+		key := common.Hash(step.Pops[0].Bytes32())
+		step.StorageWrites = []StorageAccess{{
+			Key:   key,
+			Value: step.Pops[1].Bytes32(),
+			Prev:  evm.StateDB.GetState(scope.Contract.Address(), key),
+		}}
+	}
+}
+
+// finish captures the post-execution deltas of a step.
+func (r *StepRecorder) finish(index int, evm *EVM, operation *operation, scope *ScopeContext, err error) {
+	step := &r.steps[index]
+	step.GasAfter = scope.Contract.Gas
+	step.Refund = evm.StateDB.GetRefund()
+	if err != nil && err != errStopToken {
+		// The write of a failing SSTORE, e.g. in a static call, didn't happen
+		step.Err, step.StorageWrites = err, nil
+		return
+	}
+	if _, n := stackDelta(step.Op, operation); n > 0 {
+		step.Pushes = make([]uint256.Int, n)
+		for i := range step.Pushes {
+			step.Pushes[i] = *scope.Stack.Back(i)
+		}
+	}
+	if step.Op == SLOAD {
+		step.StorageReads = []StorageAccess{{Key: step.Pops[0].Bytes32(), Value: step.Pushes[0].Bytes32()}}
+	}
+}
+
+// stackDelta returns the number of stack items op consumes and produces. The
+// stack bounds of DUPn and SWAPn cover the items they only read or reorder:
+// DUPn consumes nothing and produces the copy, SWAPn replaces the n+1 items it
+// reorders.
+func stackDelta(op OpCode, operation *operation) (pops, pushes int) {
+	switch {
+	case op >= DUP1 && op <= DUP16:
+		return 0, 1
+	case op >= SWAP1 && op <= SWAP16:
+		return int(op-SWAP1) + 2, int(op-SWAP1) + 2
+	}
+	return operation.minStack, int(params.StackLimit) + operation.minStack - operation.maxStack
+}
+
+// fail records the error of a step that couldn't execute.
+func (r *StepRecorder) fail(index int, cost uint64, err error) {
+	step := &r.steps[index]
+	step.GasCost, step.Err = cost, err
+}
@@ -0,0 +1,259 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// StructLogConfig are the configuration options of the StructLogger. The
+// first group mirrors the options of geth's default tracer. The extensions are
+// off by default, in which case the output is identical to geth's.
+type StructLogConfig struct {
+	EnableMemory   bool // enable memory capture
+	DisableStack   bool // disable stack capture
+	DisableStorage bool // disable storage capture
+	Limit          int  // maximum length of output, but zero means unlimited
+
+	EnableMemSizeBefore   bool // report the memory size before each step
+	EnableStorageBefore   bool // report the value of accessed storage slots before each step
+	EnableAccountsTouched bool // report the accounts accessed by each step
+}
+
+// StructLogRes is a step of the struct log, in the JSON layout of geth's
+// debug_traceTransaction.
+type StructLogRes struct {
+	Pc            uint64             `json:"pc"`
+	Op            string             `json:"op"`
+	Gas           uint64             `json:"gas"`
+	GasCost       uint64             `json:"gasCost"`
+	Depth         int                `json:"depth"`
+	Error         string             `json:"error,omitempty"`
+	Stack         *[]string          `json:"stack,omitempty"`
+	Memory        *[]string          `json:"memory,omitempty"`
+	Storage       *map[string]string `json:"storage,omitempty"`
+	RefundCounter uint64             `json:"refund,omitempty"`
+
+	MemSizeBefore   *uint64            `json:"memSizeBefore,omitempty"`
+	StorageBefore   *map[string]string `json:"storageBefore,omitempty"`
+	AccountsTouched *[]string          `json:"accountsTouched,omitempty"`
+}
+
+// StructLogResult is the result of a transaction traced by the StructLogger, in
+// the JSON layout of geth's debug_traceTransaction.
+type StructLogResult struct {
+	Gas         uint64         `json:"gas"`
+	Failed      bool           `json:"failed"`
+	ReturnValue string         `json:"returnValue"`
+	StructLogs  []StructLogRes `json:"structLogs"`
+}
+
+// StructLogger is an EVMLogger producing the struct logs of geth's default
+// tracer, which the bus-mapping consumes as GethExecTrace, optionally extended
+// with the state the bus-mapping otherwise has to fetch from a node.
+//
+// The StructLogger is meant to trace a single transaction.
+type StructLogger struct {
+	cfg StructLogConfig
+	env *EVM
+
+	storage  map[common.Address]map[common.Hash]common.Hash
+	logs     []StructLogRes
+	gasLimit uint64
+	usedGas  uint64
+	output   []byte
+	err      error
+}
+
+// NewStructLogger returns a new struct logger.
+func NewStructLogger(cfg *StructLogConfig) *StructLogger {
+	logger := &StructLogger{
+		storage: make(map[common.Address]map[common.Hash]common.Hash),
+	}
+	if cfg != nil {
+		logger.cfg = *cfg
+	}
+	return logger
+}
+
+// CaptureTxStart implements the EVMLogger interface.
+func (l *StructLogger) CaptureTxStart(gasLimit uint64) {
+	l.gasLimit = gasLimit
+}
+
+// CaptureTxEnd implements the EVMLogger interface.
+func (l *StructLogger) CaptureTxEnd(restGas uint64) {
+	l.usedGas = l.gasLimit - restGas
+}
+
+// CaptureStart implements the EVMLogger interface.
+func (l *StructLogger) CaptureStart(env *EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	l.env = env
+}
+
+// CaptureEnd implements the EVMLogger interface.
+func (l *StructLogger) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	l.output = output
+	l.err = err
+}
+
+// CaptureEnter implements the EVMLogger interface.
+func (l *StructLogger) CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+// CaptureExit implements the EVMLogger interface.
+func (l *StructLogger) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+// CaptureFault implements the EVMLogger interface. Faults are reported by
+// CaptureState already.
+func (l *StructLogger) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, depth int, err error) {
+}
+
+// CaptureState implements the EVMLogger interface, formatting the step the
+// same way geth's default tracer does.
+func (l *StructLogger) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, rData []byte, depth int, err error) {
+	if l.cfg.Limit != 0 && l.cfg.Limit <= len(l.logs) {
+		return
+	}
+	var (
+		memory   = scope.Memory
+		stack    = scope.Stack
+		contract = scope.Contract
+	)
+	log := StructLogRes{
+		Pc:            pc,
+		Op:            op.String(),
+		Gas:           gas,
+		GasCost:       cost,
+		Depth:         depth,
+		RefundCounter: l.env.StateDB.GetRefund(),
+	}
+	if err != nil {
+		log.Error = err.Error()
+	}
+	if !l.cfg.DisableStack {
+		items := make([]string, len(stack.data))
+		for i, item := range stack.data {
+			items[i] = item.Hex()
+		}
+		log.Stack = &items
+	}
+	if l.cfg.EnableMemory {
+		words := make([]string, 0, (memory.Len()+31)/32)
+		for i := 0; i+32 <= memory.Len(); i += 32 {
+			words = append(words, fmt.Sprintf("%x", memory.Data()[i:i+32]))
+		}
+		log.Memory = &words
+	}
+	if l.cfg.EnableMemSizeBefore {
+		size := uint64(memory.Len())
+		log.MemSizeBefore = &size
+	}
+	if (op == SLOAD && stack.len() >= 1) || (op == SSTORE && stack.len() >= 2) {
+		key := common.Hash(stack.Back(0).Bytes32())
+		if l.cfg.EnableStorageBefore {
+			before := map[string]string{
+				fmt.Sprintf("%x", key): fmt.Sprintf("%x", l.env.StateDB.GetState(contract.Address(), key)),
+			}
+			log.StorageBefore = &before
+		}
+		if !l.cfg.DisableStorage {
+			if l.storage[contract.Address()] == nil {
+				l.storage[contract.Address()] = make(map[common.Hash]common.Hash)
+			}
+			// Reads record the current value, writes the value written.
+			if op == SLOAD {
+				l.storage[contract.Address()][key] = l.env.StateDB.GetState(contract.Address(), key)
+			} else {
+				l.storage[contract.Address()][key] = stack.Back(1).Bytes32()
+			}
+			storage := make(map[string]string, len(l.storage[contract.Address()]))
+			for k, v := range l.storage[contract.Address()] {
+				storage[fmt.Sprintf("%x", k)] = fmt.Sprintf("%x", v)
+			}
+			log.Storage = &storage
+		}
+	}
+	if l.cfg.EnableAccountsTouched {
+		if touched := accountsTouched(op, stack, contract); len(touched) > 0 {
+			accounts := make([]string, len(touched))
+			for i, addr := range touched {
+				accounts[i] = fmt.Sprintf("%x", addr)
+			}
+			log.AccountsTouched = &accounts
+		}
+	}
+	l.logs = append(l.logs, log)
+}
+
+// accountsTouched returns the accounts whose state op accesses, as far as it
+// can be told before the opcode executes.
+func accountsTouched(op OpCode, stack *Stack, contract *Contract) []common.Address {
+	switch op {
+	case BALANCE, EXTCODESIZE, EXTCODECOPY, EXTCODEHASH:
+		if stack.len() >= 1 {
+			return []common.Address{stack.Back(0).Bytes20()}
+		}
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		if stack.len() >= 2 {
+			return []common.Address{stack.Back(1).Bytes20()}
+		}
+	case SELFDESTRUCT:
+		if stack.len() >= 1 {
+			return []common.Address{contract.Address(), stack.Back(0).Bytes20()}
+		}
+	case SLOAD, SSTORE, SELFBALANCE, CREATE, CREATE2:
+		return []common.Address{contract.Address()}
+	}
+	return nil
+}
+
+// StructLogs returns the struct logs captured so far.
+func (l *StructLogger) StructLogs() []StructLogRes {
+	return l.logs
+}
+
+// Result returns the result of the traced transaction. The return value holds
+// the returned data on success and the revert reason on revert.
+func (l *StructLogger) Result() *StructLogResult {
+	failed := l.err != nil
+	returnVal := fmt.Sprintf("%x", l.output)
+	if failed && l.err != ErrExecutionReverted {
+		returnVal = ""
+	}
+	logs := l.logs
+	if logs == nil {
+		logs = []StructLogRes{}
+	}
+	return &StructLogResult{
+		Gas:         l.usedGas,
+		Failed:      failed,
+		ReturnValue: returnVal,
+		StructLogs:  logs,
+	}
+}
+
+// GetResult returns the JSON encoded result, byte for byte the one of geth's
+// debug_traceTransaction with the default tracer when no extension is enabled.
+func (l *StructLogger) GetResult() (json.RawMessage, error) {
+	return json.Marshal(l.Result())
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Opcodes of EIP-1153.
+const (
+	TLOAD  OpCode = 0x5c
+	TSTORE OpCode = 0x5d
+)
+
+func init() {
+	for op, name := range map[OpCode]string{
+		TLOAD:  "TLOAD",
+		TSTORE: "TSTORE",
+	} {
+		opCodeToString[op] = name
+		stringToOp[name] = op
+	}
+	activators[1153] = enable1153
+}
+
+// enable1153 applies EIP-1153 (Transient Storage).
+func enable1153(jt *JumpTable) {
+	jt[TLOAD] = &operation{
+		execute:     opTload,
+		constantGas: params.WarmStorageReadCostEIP2929,
+		minStack:    minStack(1, 1),
+		maxStack:    maxStack(1, 1),
+	}
+	jt[TSTORE] = &operation{
+		execute:     opTstore,
+		constantGas: params.WarmStorageReadCostEIP2929,
+		minStack:    minStack(2, 0),
+		maxStack:    maxStack(2, 0),
+	}
+}
+
+// opTload implements the TLOAD opcode.
+func opTload(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	loc := scope.Stack.peek()
+	val := interpreter.evm.GetTransientState(scope.Contract.Address(), loc.Bytes32())
+	loc.SetBytes(val.Bytes())
+	return nil, nil
+}
+
+// opTstore implements the TSTORE opcode.
+func opTstore(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	if interpreter.readOnly {
+		return nil, ErrWriteProtection
+	}
+	loc := scope.Stack.pop()
+	val := scope.Stack.pop()
+	interpreter.evm.setTransientState(scope.Contract.Address(), loc.Bytes32(), val.Bytes32())
+	return nil, nil
+}
+
+// transientStorage is the storage of EIP-1153, living for a transaction.
+type transientStorage map[common.Address]map[common.Hash]common.Hash
+
+// transientChange is a write to the transient storage, with the value it
+// overwrote.
+type transientChange struct {
+	addr common.Address
+	key  common.Hash
+	prev common.Hash
+}
+
+// transientRevision marks the journal length at the time a snapshot was taken.
+type transientRevision struct {
+	id     int
+	length int
+}
+
+// transientJournal holds the transient storage of the current transaction
+// and the writes to undo when the StateDB reverts to a snapshot, as the
+// StateDB journal does for persistent storage.
+type transientJournal struct {
+	storage   transientStorage
+	changes   []transientChange
+	revisions []transientRevision
+}
+
+func (j *transientJournal) get(addr common.Address, key common.Hash) common.Hash {
+	return j.storage[addr][key]
+}
+
+func (j *transientJournal) set(addr common.Address, key, value common.Hash) {
+	if j.storage == nil {
+		j.storage = make(transientStorage)
+	}
+	slots, ok := j.storage[addr]
+	if !ok {
+		slots = make(map[common.Hash]common.Hash)
+		j.storage[addr] = slots
+	}
+	j.changes = append(j.changes, transientChange{addr: addr, key: key, prev: slots[key]})
+	if value == (common.Hash{}) {
+		delete(slots, key)
+	} else {
+		slots[key] = value
+	}
+}
+
+func (j *transientJournal) snapshot(id int) {
+	j.revisions = append(j.revisions, transientRevision{id: id, length: len(j.changes)})
+}
+
+// revert undoes the writes made since the snapshot id, latest first, and drops
+// every revision taken after and including it.
+func (j *transientJournal) revert(id int) {
+	for i := len(j.revisions) - 1; i >= 0; i-- {
+		if j.revisions[i].id != id {
+			continue
+		}
+		length := j.revisions[i].length
+		for k := len(j.changes) - 1; k >= length; k-- {
+			change := j.changes[k]
+			if change.prev == (common.Hash{}) {
+				delete(j.storage[change.addr], change.key)
+			} else {
+				j.storage[change.addr][change.key] = change.prev
+			}
+		}
+		j.changes, j.revisions = j.changes[:length], j.revisions[:i]
+		return
+	}
+}
+
+func (j *transientJournal) reset() {
+	j.storage, j.changes, j.revisions = nil, j.changes[:0], j.revisions[:0]
+}
+
+// GetTransientState returns the transient storage slot key of addr.
+func (evm *EVM) GetTransientState(addr common.Address, key common.Hash) common.Hash {
+	return evm.transient.get(addr, key)
+}
+
+// setTransientState writes the transient storage slot key of addr, journaled
+// so that reverting the enclosing frame undoes it.
+func (evm *EVM) setTransientState(addr common.Address, key, value common.Hash) {
+	evm.transient.set(addr, key, value)
+}
+
+// ClearTransientStorage discards the transient storage, at the end of every
+// transaction.
+func (evm *EVM) ClearTransientStorage() {
+	evm.transient.reset()
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Unsupported lists the features of the EVM the zkEVM circuit can't prove yet.
+// Executing one of them fails with ErrUnsupportedOpCode before any gas is
+// charged, so the outcome doesn't depend on the gas provided. The error sticks
+// to the EVM until it's Reset: every frame up to the root fails with it, so it
+// ends up in the result of the transaction.
+//
+// Precompiles are checked both when called from contract code and when called
+// directly by a transaction.
+type Unsupported struct {
+	Ops         [256]bool                   // Opcodes that may not be executed
+	Precompiles map[common.Address]struct{} // Precompiles that may not be called
+	// BLOCKHASH may only read the hashes of the latest BlockHashWindow blocks,
+	// zero meaning the whole window of the EVM.
+	BlockHashWindow uint64
+}
+
+// check returns an error if op, with the given stack, uses an unsupported
+// feature or if an unsupported feature was already hit by the EVM.
+func (u *Unsupported) check(evm *EVM, op OpCode, pc uint64, stack *Stack) error {
+	if evm.unsupportedErr == nil && u.unsupported(evm, op, stack) {
+		err := &ErrUnsupportedOpCode{Op: op, PC: pc, Depth: evm.depth}
+		if !u.Ops[op] && (op == CALL || op == CALLCODE || op == DELEGATECALL || op == STATICCALL) {
+			addr := common.Address(stack.Back(1).Bytes20())
+			err.Address = &addr
+		}
+		evm.unsupportedErr = err
+	}
+	if evm.unsupportedErr != nil {
+		return evm.unsupportedErr
+	}
+	return nil
+}
+
+// checkPrecompile returns an error if addr, the destination of a transaction,
+// is an unsupported precompile. As transactions run no CALL, the error reports
+// one at depth 0.
+func (u *Unsupported) checkPrecompile(evm *EVM, addr common.Address) error {
+	if evm.unsupportedErr == nil {
+		if _, ok := u.Precompiles[addr]; ok {
+			evm.unsupportedErr = &ErrUnsupportedOpCode{Op: CALL, Depth: evm.depth, Address: &addr}
+		}
+	}
+	if evm.unsupportedErr != nil {
+		return evm.unsupportedErr
+	}
+	return nil
+}
+
+// unsupported reports whether op, with the given stack, uses an unsupported
+// feature. Calls are reported only if they target an unsupported precompile.
+func (u *Unsupported) unsupported(evm *EVM, op OpCode, stack *Stack) bool {
+	if u.Ops[op] {
+		return true
+	}
+	switch op {
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		addr := common.Address(stack.Back(1).Bytes20())
+		if _, ok := u.Precompiles[addr]; !ok {
+			return false
+		}
+		_, ok := evm.precompile(addr)
+		return ok
+
+	case BLOCKHASH:
+		if u.BlockHashWindow == 0 {
+			return false
+		}
+		num, overflow := stack.Back(0).Uint64WithOverflow()
+		current := evm.Context.BlockNumber.Uint64()
+		return !overflow && num < current && current-num > u.BlockHashWindow
+	}
+	return false
+}